@@ -8,6 +8,7 @@ import (
 	"github.com/unrolled/secure"
 
 	"github.com/develersrl/lunches/models"
+	"github.com/develersrl/lunches/pkg/tinabot"
 	"github.com/gobuffalo/buffalo-pop/pop/popmw"
 	i18n "github.com/gobuffalo/mw-i18n"
 	"github.com/gobuffalo/packr"
@@ -53,6 +54,7 @@ func App() *buffalo.App {
 		//  c.Value("tx").(*pop.Connection)
 		// Remove to disable this.
 		app.Use(popmw.Transaction(models.DB))
+		tinabot.SetMarkDB(models.DB)
 
 		// Setup and use translations:
 		app.Use(translations())
@@ -60,6 +62,7 @@ func App() *buffalo.App {
 		app.GET("/", HomeHandler)
 
 		app.POST("/slack/handler", SlackHandler)
+		app.POST("/slack/interactive", SlackInteractiveHandler)
 		app.POST("/email/handler", EmailHandler)
 		app.ServeFiles("/", assetsBox) // serve files from the public directory
 	}
@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/slackbot"
+	"github.com/develersrl/lunches/pkg/tinabot"
 	"github.com/develersrl/lunches/pkg/tuttobene"
 	"github.com/gobuffalo/buffalo"
 	"github.com/mailgun/mailgun-go/v3"
@@ -117,6 +119,9 @@ func EmailHandler(c buffalo.Context) error {
 
 			date := m.Date.Format("02/01/2006")
 			api.PostMessage(channel, slack.MsgOptionText("Ho appena ricevuto e impostato correttamente il menu per il giorno "+date, false))
+
+			tina := tinabot.New(slackbot.New(os.Getenv("BOT_ID"), api), b)
+			tina.PlaceStandingOrders(*m)
 			return nil
 		}
 
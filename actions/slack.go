@@ -77,3 +77,46 @@ func SlackHandler(c buffalo.Context) error {
 
 	return nil
 }
+
+// SlackInteractiveHandler handles the clicks on the message buttons sent by the bot.
+func SlackInteractiveHandler(c buffalo.Context) error {
+	slackToken := os.Getenv("SLACK_BOT_TOKEN")
+	if slackToken == "" {
+		log.Fatalln("No SLACK_BOT_TOKEN found!")
+	}
+	accessToken := os.Getenv("SLACK_VERIFICATION_TOKEN")
+	if accessToken == "" {
+		log.Fatalln("No SLACK_VERIFICATION_TOKEN found!")
+	}
+	botID := os.Getenv("BOT_ID")
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		log.Fatalln("No redis URL found!")
+	}
+
+	var cb slack.InteractionCallback
+	err := json.Unmarshal([]byte(c.Request().FormValue("payload")), &cb)
+	if err != nil {
+		log.Println(err)
+		c.Response().WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	if cb.Token != accessToken {
+		log.Println("Slack interactive callback: wrong verification token")
+		c.Response().WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+
+	api := slack.New(slackToken)
+
+	brain := brain.New(redisURL)
+	defer brain.Close()
+
+	bot := slackbot.New(botID, api)
+	tina := tinabot.New(bot, brain)
+	tina.AddCommands()
+
+	bot.HandleAction(&cb)
+	return nil
+}
@@ -2,9 +2,14 @@ package grifts
 
 import (
 	"github.com/develersrl/lunches/actions"
+	"github.com/develersrl/lunches/models"
+	"github.com/develersrl/lunches/pkg/tinabot"
 	"github.com/gobuffalo/buffalo"
 )
 
 func init() {
 	buffalo.Grifts(actions.App())
+
+	// the tasks can run without the web app, e.g. with "buffalo task"
+	tinabot.SetMarkDB(models.DB)
 }
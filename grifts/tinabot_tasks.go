@@ -2,6 +2,7 @@ package grifts
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -14,80 +15,46 @@ import (
 	"github.com/develersrl/lunches/pkg/tinabot"
 
 	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/calendar"
+	"github.com/develersrl/lunches/pkg/scheduler"
+	"github.com/develersrl/lunches/pkg/slackbot"
 	"github.com/go-redis/redis"
 	"github.com/mailgun/mailgun-go/v3"
 	. "github.com/markbates/grift/grift"
 	"github.com/nlopes/slack"
-	"github.com/robfig/cron"
 )
 
 var _ = Namespace("tinabot", func() {
 
-	Desc("cron", "Execute scheduled tasks")
+	Desc("cron", "Execute the scheduled tasks not executed yet. Not needed if the web app runs the scheduler")
 	Add("cron", func(c *Context) error {
 		redisURL := os.Getenv("REDIS_URL")
 		if redisURL == "" {
-			log.Fatalln("No redis URL found!")
+			return errors.New("no redis URL found")
 		}
 
 		timerInterval := 10 * time.Minute
 		interval := os.Getenv("INTERVAL_MINUTES")
 		if interval != "" {
 			n, err := strconv.Atoi(interval)
-			if err != nil {
+			if err == nil && n > 0 {
 				timerInterval = time.Duration(n) * time.Minute
+			} else {
+				log.Println("Invalid INTERVAL_MINUTES: " + interval)
 			}
 		}
 
 		brain := brain.New(redisURL)
 		defer brain.Close()
 
-		var sched []string
-		err := brain.Get("cron", &sched)
-		if err == redis.Nil || len(sched) == 0 {
-			log.Println("No cron set")
-			return nil
-		}
-
 		loc, err := time.LoadLocation("Europe/Rome")
 		if err != nil {
 			log.Println("LoadLocation error: ", err)
 			return nil
 		}
 
-		for i, s := range sched {
-			r := strings.SplitN(s, ";", 2)
-			if len(r) < 2 {
-				log.Println("Malformed cron string: " + s)
-				continue
-			}
-			sch, err := cron.ParseStandard(r[0])
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			txt := strings.TrimSpace(r[1])
-			now := time.Now().In(loc)
-			now = now.Add(-timerInterval / 2)
-			next := sch.Next(now)
-
-			if now.Add(timerInterval).Sub(next) > 0 {
-				log.Printf("Executing cron #%d - %s", i, s)
-
-				args := strings.Split(txt, " ")
-				if len(args) < 1 {
-					log.Println("No task specified!")
-					continue
-				}
-				task := "tinabot:" + args[0]
-				ctx := NewContext(task)
-				ctx.Args = args[1:]
-				err := Run(task, ctx)
-				if err != nil {
-					log.Println(err)
-				}
-			}
-		}
+		// the runs of the last interval, the ones already executed are skipped
+		scheduler.New(brain, scheduler.RunTask, loc).CatchUp(time.Now(), timerInterval)
 		return nil
 	})
 
@@ -95,11 +62,11 @@ var _ = Namespace("tinabot", func() {
 	Add("post", func(c *Context) error {
 		token := os.Getenv("SLACK_BOT_TOKEN")
 		if token == "" {
-			log.Fatalln("No slackbot token found!")
+			return errors.New("no slackbot token found")
 		}
 
 		if len(c.Args) < 2 {
-			log.Fatalln("Not enough arguments, usage: post <channel> [<options>] <message>")
+			return errors.New("not enough arguments, usage: post <channel> [<options>] <message>")
 		}
 		channel := c.Args[0]
 		onlyValidOrder := false
@@ -123,12 +90,16 @@ var _ = Namespace("tinabot", func() {
 
 		redisURL := os.Getenv("REDIS_URL")
 		if redisURL == "" {
-			log.Fatalln("No redis URL found!")
+			return errors.New("no redis URL found")
 		}
 
 		brain := brain.New(redisURL)
 		defer brain.Close()
 
+		if closedToday(brain) {
+			return nil
+		}
+
 		var order tinabot.Order
 		order.Load(brain)
 
@@ -152,6 +123,9 @@ var _ = Namespace("tinabot", func() {
 		msg = strings.Replace(msg, "$ORDER", order.Format(true, false), -1)
 		msg = strings.Replace(msg, "$BILL", order.Format(true, true), -1)
 		msg = strings.Replace(msg, "$BILL_NONAMES", order.Format(false, true), -1)
+		if strings.Contains(msg, "$MISSING") {
+			msg = strings.Replace(msg, "$MISSING", formatMissing(brain, &order), -1)
+		}
 		msg = strings.Replace(msg, "\\n", "\n", -1)
 
 		api := slack.New(token)
@@ -175,12 +149,16 @@ var _ = Namespace("tinabot", func() {
 
 		redisURL := os.Getenv("REDIS_URL")
 		if redisURL == "" {
-			log.Fatalln("No redis URL found!")
+			return errors.New("no redis URL found")
 		}
 
 		brain := brain.New(redisURL)
 		defer brain.Close()
 
+		if closedToday(brain) {
+			return nil
+		}
+
 		var order tinabot.Order
 		order.Load(brain)
 
@@ -234,19 +212,44 @@ var _ = Namespace("tinabot", func() {
 		return err
 	})
 
-	Desc("reminder", "send the users the reminder to order")
+	Desc("ledger", "post the weekly summary of the payments ledger. Usage: ledger <channel>")
+	Add("ledger", func(c *Context) error {
+		token := os.Getenv("SLACK_BOT_TOKEN")
+		if token == "" {
+			return errors.New("no slackbot token found")
+		}
+
+		if len(c.Args) < 1 {
+			return errors.New("not enough arguments, usage: ledger <channel>")
+		}
+
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			return errors.New("no redis URL found")
+		}
+
+		brain := brain.New(redisURL)
+		defer brain.Close()
+
+		ledger := tinabot.LoadLedger(brain)
+		msg := "Ecco il riepilogo dei pagamenti dell'ultima settimana:\n" + ledger.Summary(time.Now().AddDate(0, 0, -7))
+
+		api := slack.New(token)
+		api.PostMessage(c.Args[0], slack.MsgOptionText(msg, false))
+		return nil
+	})
+
+	Desc("reminder", "send the users the reminder to order, at the time chosen by each one. Run it every few minutes")
 	Add("reminder", func(c *Context) error {
 		redisURL := os.Getenv("REDIS_URL")
 		if redisURL == "" {
-			log.Fatalln("No redis URL found!")
+			return errors.New("no redis URL found")
 		}
 
 		brain := brain.New(redisURL)
 		defer brain.Close()
 
-		var remind map[string]int
-		err := brain.Get("remind", &remind)
-		if err == redis.Nil || len(remind) == 0 {
+		if closedToday(brain) {
 			return nil
 		}
 
@@ -254,7 +257,7 @@ var _ = Namespace("tinabot", func() {
 		order.Load(brain)
 
 		var menu tuttobene.Menu
-		err = brain.Get("menu", &menu)
+		err := brain.Get("menu", &menu)
 		if err == redis.Nil {
 			log.Println("No menu found")
 			return nil
@@ -264,56 +267,143 @@ var _ = Namespace("tinabot", func() {
 			return nil
 		}
 
-		token := os.Getenv("SLACK_BOT_TOKEN")
-		if token == "" {
-			log.Fatalln("No slackbot token found!")
-		}
-		api := slack.New(token)
-
 		loc, err := time.LoadLocation("Europe/Rome")
 		if err != nil {
 			log.Println("LoadLocation error: ", err)
 			return nil
 		}
 
-		weekmask := 1 << uint(time.Now().In(loc).Weekday())
+		due, err := tinabot.DueReminders(brain, time.Now().In(loc))
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		token := os.Getenv("SLACK_BOT_TOKEN")
+		if token == "" {
+			return errors.New("no slackbot token found")
+		}
+		api := slack.New(token)
+
+		for _, userid := range due {
+			user, err := api.GetUserInfo(userid)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
 
-		fmtmsg := "Ciao %s, scusa il disturbo. Vedo che non hai ancora ordinato il pranzo e mi hai chiesto di ricordartelo. Ecco il menù di oggi:\n" + menu.String()
-		for userid, v := range remind {
-			if v&weekmask != 0 {
-				user, err := api.GetUserInfo(userid)
+			if _, ok := order.Users[tinabot.User{Name: user.Name, ID: user.ID}]; !ok {
+				log.Printf("Sending reminder to %s\n", user.Name)
+				_, _, ch, err := api.OpenIMChannel(user.ID)
 				if err != nil {
 					log.Println(err)
 					continue
 				}
 
-				if _, ok := order.Users[tinabot.User{Name: user.Name, ID: user.ID}]; !ok {
-					log.Printf("Sending reminder to %s\n", user.Name)
-					_, _, ch, err := api.OpenIMChannel(user.ID)
-					if err != nil {
-						log.Println(err)
-						continue
-					}
+				api.PostMessage(ch, slack.MsgOptionText(tinabot.ReminderText(user.Name, menu.String()), false))
+			}
+		}
 
-					txt := fmt.Sprintf(fmtmsg, user.Name)
-					api.PostMessage(ch, slack.MsgOptionText(txt, false))
-				}
+		return nil
+	})
+
+	Desc("rate", "ask the users who ordered today to rate their dishes. Run it after lunch")
+	Add("rate", func(c *Context) error {
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			return errors.New("no redis URL found")
+		}
+
+		brain := brain.New(redisURL)
+		defer brain.Close()
+
+		if closedToday(brain) {
+			return nil
+		}
+
+		var order tinabot.Order
+		order.Load(brain)
+		if !order.IsUpdated() {
+			return nil
+		}
+
+		token := os.Getenv("SLACK_BOT_TOKEN")
+		if token == "" {
+			return errors.New("no slackbot token found")
+		}
+		api := slack.New(token)
+		bot := slackbot.New("", api)
+		bot.SetStore(brain)
+
+		date := order.Timestamp.Format("2006-01-02")
+		for u, choices := range order.Users {
+			if u.ID == "" {
+				continue
+			}
+			dishes := tinabot.OrderedDishes(choices)
+			if len(dishes) == 0 {
+				continue
+			}
+
+			_, _, ch, err := api.OpenIMChannel(u.ID)
+			if err != nil {
+				log.Println(err)
+				continue
 			}
+			log.Printf("Asking %s to rate the lunch\n", u.Name)
+			tinabot.AskRating(bot, ch, u, date, strings.Join(dishes, "\n"), 0)
+		}
+		return nil
+	})
+
+	Desc("poll", "close the lunch poll when its time has come and announce the result. Run it every few minutes")
+	Add("poll", func(c *Context) error {
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			return errors.New("no redis URL found")
 		}
 
+		brain := brain.New(redisURL)
+		defer brain.Close()
+
+		loc, err := time.LoadLocation("Europe/Rome")
+		if err != nil {
+			log.Println("LoadLocation error: ", err)
+			return nil
+		}
+
+		p, err := tinabot.ClosePoll(brain, time.Now().In(loc), false)
+		if err != nil || p == nil {
+			return err
+		}
+
+		token := os.Getenv("SLACK_BOT_TOKEN")
+		if token == "" {
+			return errors.New("no slackbot token found")
+		}
+		api := slack.New(token)
+		api.PostMessage(p.Channel, slack.MsgOptionText(p.Result(), false))
 		return nil
 	})
 
-	Desc("mark", "mark the lunch on the spreadsheet")
+	Desc("mark", "mark the lunch on the spreadsheet. Usage: mark [<admin channel>]")
 	Add("mark", func(c *Context) error {
 		redisURL := os.Getenv("REDIS_URL")
 		if redisURL == "" {
-			log.Fatalln("No redis URL found!")
+			return errors.New("no redis URL found")
 		}
 
 		brain := brain.New(redisURL)
 		defer brain.Close()
 
+		if closedToday(brain) {
+			return nil
+		}
+
+		codes := tinabot.LoadMarkCodes(brain)
 		var order tinabot.Order
 		order.Load(brain)
 
@@ -323,7 +413,7 @@ var _ = Namespace("tinabot", func() {
 
 		token := os.Getenv("SLACK_BOT_TOKEN")
 		if token == "" {
-			log.Fatalln("No slackbot token found!")
+			return errors.New("no slackbot token found")
 		}
 		api := slack.New(token)
 
@@ -333,8 +423,29 @@ var _ = Namespace("tinabot", func() {
 			log.Println(err)
 			return nil
 		}
+		err = tinabot.RecordGuests(brain, &order)
+		if err != nil {
+			log.Println(err)
+		}
+
 		log.Printf("Today we have %d users for lunch\n", len(order.Users))
 		for u, v := range order.Users {
+			if g, ok := order.Guests[u.Name]; ok && u.ID == "" {
+				log.Printf("User %s is a guest of %s, lunch not marked\n", u.Name, g.Host.Name)
+				if g.Host.ID == "" {
+					continue
+				}
+				_, _, ch, err := api.OpenIMChannel(g.Host.ID)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				txt := fmt.Sprintf("Ciao %s, oggi il tuo ospite %s ha ordinato:\n%s\n-------\nIl costo di €%s è addebitato a: %s. Non l'ho segnato sul foglio dei pranzi.",
+					g.Host.Name, u.Name, v.String(), v.Price().String(), g.Payer())
+				api.PostMessage(ch, slack.MsgOptionText(txt, false))
+				continue
+			}
+
 			found := false
 			log.Printf("Marking lunch for user %s - ID [%s]\n", u.Name, u.ID)
 			for _, user := range users {
@@ -350,13 +461,13 @@ var _ = Namespace("tinabot", func() {
 					txt := fmt.Sprintf("Ciao %s, oggi hai ordinato:\n%s\n-------\n", user.Name, v.String())
 
 					log.Printf("Calling mark function for user %s...\n", u.Name)
-					err = tinabot.MarkUser(&user, v.Mark())
+					err = tinabot.MarkAndRecord(brain, &user, codes.Code(v))
 					if err != nil {
 						log.Printf("ERROR marking user %s: %s\n", u.Name, err.Error())
 						txt = txt + fmt.Sprintf("C'è stato un errore nel segnare il pranzo: %s.", err.Error())
 					} else {
-						log.Printf("Marking user %s: %s\n", u.Name, v.Mark())
-						txt = txt + fmt.Sprintf("Ho segnato `%s` sul foglio dei pranzi.\nSe non fosse corretto, usa il comando `segna` per modificarlo.", v.Mark())
+						log.Printf("Marking user %s: %s\n", u.Name, codes.Code(v))
+						txt = txt + fmt.Sprintf("Ho segnato `%s` sul foglio dei pranzi.\nSe non fosse corretto, usa il comando `segna` per modificarlo.", codes.Code(v))
 					}
 
 					api.PostMessage(ch, slack.MsgOptionText(txt, false))
@@ -366,10 +477,151 @@ var _ = Namespace("tinabot", func() {
 			}
 			if !found {
 				log.Printf("WARN:user %s - ID [%s] not found, lunch not marked.\n", u.Name, u.ID)
+				err = tinabot.RecordMarkFailure(brain, u, codes.Code(v), fmt.Errorf("utente non trovato su Slack"))
+				if err != nil {
+					log.Println(err)
+				}
 			}
 		}
 
+		markWithoutOrder(api, brain, codes, &order, users)
+
+		if len(c.Args) > 0 {
+			postMarkSummary(api, brain, c.Args[0])
+		}
+
 		log.Printf("Marking lunch fineshed correctly\n")
 		return nil
 	})
+
+	Desc("markretry", "retry to mark the lunches that failed today. Usage: markretry [<admin channel>]")
+	Add("markretry", func(c *Context) error {
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			return errors.New("no redis URL found")
+		}
+
+		brain := brain.New(redisURL)
+		defer brain.Close()
+
+		token := os.Getenv("SLACK_BOT_TOKEN")
+		if token == "" {
+			return errors.New("no slackbot token found")
+		}
+		api := slack.New(token)
+
+		retried := false
+		for id, s := range tinabot.TodayMarkStates(brain) {
+			if !s.CanRetry() {
+				continue
+			}
+			retried = true
+
+			user, err := api.GetUserInfo(id)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			log.Printf("Retrying to mark user %s: %s (attempt %d)\n", user.Name, s.Food, s.Attempts+1)
+			err = tinabot.MarkAndRecord(brain, user, s.Food)
+			if err != nil {
+				log.Printf("ERROR marking user %s: %s\n", user.Name, err.Error())
+				continue
+			}
+
+			_, _, ch, err := api.OpenIMChannel(user.ID)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			txt := fmt.Sprintf("Ciao %s, sono riuscito a segnare `%s` sul foglio dei pranzi.", user.Name, s.Food)
+			api.PostMessage(ch, slack.MsgOptionText(txt, false))
+		}
+
+		if retried && len(c.Args) > 0 {
+			postMarkSummary(api, brain, c.Args[0])
+		}
+		return nil
+	})
 })
+
+// formatMissing returns the mentions of the users with the reminder who have not ordered yet
+func formatMissing(brain *brain.Brain, order *tinabot.Order) string {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return ""
+	}
+
+	var r []string
+	for _, id := range tinabot.NotOrdered(brain, order, time.Now().In(loc)) {
+		r = append(r, "<@"+id+">")
+	}
+	return strings.Join(r, " ")
+}
+
+// markWithoutOrder marks the users who have not ordered: the ones going out for lunch with the
+// lunch poll, and the absent ones who asked for "Niente"
+func markWithoutOrder(api *slack.Client, brain *brain.Brain, codes *tinabot.MarkCodes, order *tinabot.Order, users []slack.User) {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+	now := time.Now().In(loc)
+
+	// close the lunch poll if the poll task has not done it yet
+	p, err := tinabot.ClosePoll(brain, now, false)
+	if err != nil {
+		log.Println(err)
+	} else if p != nil {
+		api.PostMessage(p.Channel, slack.MsgOptionText(p.Result(), false))
+	}
+
+	marks := make(map[string]string)
+	for _, id := range tinabot.AbsentToMark(brain, now) {
+		marks[id] = codes.Nothing
+	}
+	for _, id := range tinabot.PollAttendees(brain, now) {
+		marks[id] = codes.OutMark()
+	}
+	for u := range order.Users {
+		delete(marks, u.ID)
+	}
+
+	for _, user := range users {
+		mark, ok := marks[user.ID]
+		if !ok {
+			continue
+		}
+		log.Printf("User %s has not ordered, marking %s\n", user.Name, mark)
+		err := tinabot.MarkAndRecord(brain, &user, mark)
+		if err != nil {
+			log.Printf("ERROR marking user %s: %s\n", user.Name, err.Error())
+		}
+	}
+}
+
+// postMarkSummary posts on the channel who could not be marked today, if any
+func postMarkSummary(api *slack.Client, brain *brain.Brain, channel string) {
+	summary := tinabot.MarkSummary(tinabot.TodayMarkStates(brain))
+	if summary == "" {
+		return
+	}
+	api.PostMessage(channel, slack.MsgOptionText(summary, false))
+}
+
+// closedToday returns true if the office is closed today, so that the lunch tasks are skipped
+func closedToday(brain *brain.Brain) bool {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return false
+	}
+	if ok, why := calendar.IsWorkingDay(brain, time.Now().In(loc)); !ok {
+		log.Printf("Office closed today (%s), skipping\n", why)
+		return true
+	}
+	return false
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"time"
 
 	"github.com/develersrl/lunches/actions"
+	_ "github.com/develersrl/lunches/grifts" // registers the tasks run by the scheduler
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/calendar"
+	"github.com/develersrl/lunches/pkg/scheduler"
 )
 
 // main is the starting point for your Buffalo application.
@@ -14,11 +21,48 @@ import (
 // application that is. :)
 func main() {
 	app := actions.App()
-	if err := app.Serve(); err != nil {
+
+	s := startScheduler()
+	err := app.Serve()
+
+	// Serve returns when the app is shut down, stop the scheduler before exiting
+	if s != nil {
+		s.Stop()
+	}
+	if err != nil && err != context.Canceled {
 		log.Fatal(err)
 	}
 }
 
+// startScheduler starts the scheduler of the tasks set with the "cron" command,
+// unless SCHEDULER_OFF is set
+func startScheduler() *scheduler.Scheduler {
+	if os.Getenv("SCHEDULER_OFF") != "" {
+		return nil
+	}
+
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		log.Println("No redis URL found, scheduler not started")
+		return nil
+	}
+
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return nil
+	}
+
+	b := brain.New(redisURL)
+	s := scheduler.New(b, scheduler.RunTask, loc)
+	s.WorkingDay = func(day time.Time) bool {
+		ok, _ := calendar.IsWorkingDay(b, day)
+		return ok
+	}
+	s.Start()
+	return s
+}
+
 /*
 # Notes about `main.go`
 
@@ -2,13 +2,28 @@ package brain
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"reflect"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
 )
 
+// maxUpdateRetries is how many times Update retries when the key is modified concurrently
+const maxUpdateRetries = 50
+
+// ErrConflict is returned by Update when the key keeps being modified concurrently
+var ErrConflict = errors.New("too many concurrent updates, retry later")
+
+// reset sets the value pointed by q to its zero value, so that a retried update does not
+// decode over the values of the previous attempt
+func reset(q interface{}) {
+	v := reflect.ValueOf(q).Elem()
+	v.Set(reflect.Zero(v.Type()))
+}
+
 type Brain struct {
 	client *redis.Client
 }
@@ -52,6 +67,22 @@ func (b *Brain) Set(key string, val interface{}) error {
 
 	return b.client.Set(key, encoded, 0).Err()
 }
+
+// SetWithExpiry sets the key like Set, the key is removed after ttl
+func (b *Brain) SetWithExpiry(key string, val interface{}, ttl time.Duration) error {
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	return b.client.Set(key, encoded, ttl).Err()
+}
+
+// Delete removes the key
+func (b *Brain) Delete(key string) error {
+	return b.client.Del(key).Err()
+}
+
 func (b *Brain) Read(key string) (string, error) {
 	val, err := b.client.Get(key).Result()
 
@@ -76,3 +107,44 @@ func (b *Brain) Get(key string, q interface{}) error {
 func (b *Brain) Close() error {
 	return b.client.Close()
 }
+
+// Update atomically reads the key into q, calls modify and writes q back.
+// If the key does not exist q is left to its zero value. If the key is changed by someone else
+// in the meantime, the whole operation is retried: modify must only change q, without other
+// side effects. If modify returns an error nothing is written and the error is returned.
+func (b *Brain) Update(key string, q interface{}, modify func() error) error {
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := b.client.Watch(func(tx *redis.Tx) error {
+			reset(q)
+			val, err := tx.Get(key).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if err == nil {
+				if err := json.Unmarshal([]byte(val), q); err != nil {
+					return err
+				}
+			}
+
+			if err := modify(); err != nil {
+				return err
+			}
+
+			encoded, err := json.Marshal(q)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+				pipe.Set(key, encoded, 0)
+				return nil
+			})
+			return err
+		}, key)
+
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return ErrConflict
+}
@@ -3,26 +3,80 @@ package brain
 import (
 	"encoding/json"
 	"errors"
+	"sync"
+	"time"
 )
 
-type BrainMock map[string][]byte
+// BrainMock is an in memory brain, safe for concurrent use.
+// Each key has a version, so that Update behaves like the optimistic locking of redis.
+type BrainMock struct {
+	mu       sync.Mutex
+	data     map[string][]byte
+	versions map[string]int
+	expiry   map[string]time.Time
+}
 
-func NewBrainMock() BrainMock {
-	return make(BrainMock)
+func NewBrainMock() *BrainMock {
+	return &BrainMock{
+		data:     make(map[string][]byte),
+		versions: make(map[string]int),
+		expiry:   make(map[string]time.Time),
+	}
 }
 
-func (b BrainMock) Set(key string, val interface{}) error {
+// expire removes the key if its time has come, it must be called with the lock held
+func (b *BrainMock) expire(key string) {
+	if e, ok := b.expiry[key]; ok && !time.Now().Before(e) {
+		delete(b.data, key)
+		delete(b.expiry, key)
+		b.versions[key]++
+	}
+}
+
+func (b *BrainMock) Set(key string, val interface{}) error {
 	encoded, err := json.Marshal(val)
 	if err != nil {
 		return err
 	}
 
-	b[key] = encoded
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data[key] = encoded
+	b.versions[key]++
+	delete(b.expiry, key)
+
+	return nil
+}
+
+// SetWithExpiry sets the key like Set, the key is removed after ttl
+func (b *BrainMock) SetWithExpiry(key string, val interface{}, ttl time.Duration) error {
+	if err := b.Set(key, val); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expiry[key] = time.Now().Add(ttl)
+	return nil
+}
 
+// Delete removes the key
+func (b *BrainMock) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.data[key]; ok {
+		delete(b.data, key)
+		delete(b.expiry, key)
+		b.versions[key]++
+	}
 	return nil
 }
-func (b BrainMock) Read(key string) (string, error) {
-	val, ok := b[key]
+
+func (b *BrainMock) Read(key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	val, ok := b.data[key]
 
 	if !ok {
 		return "", errors.New("key not found")
@@ -31,7 +85,7 @@ func (b BrainMock) Read(key string) (string, error) {
 	return string(val), nil
 }
 
-func (b BrainMock) Get(key string, q interface{}) error {
+func (b *BrainMock) Get(key string, q interface{}) error {
 
 	val, err := b.Read(key)
 
@@ -42,7 +96,56 @@ func (b BrainMock) Get(key string, q interface{}) error {
 	return json.Unmarshal([]byte(val), q)
 }
 
-func (b BrainMock) Close() error {
-	b = nil
+// Update atomically reads the key into q, calls modify and writes q back, see Brain.Update
+func (b *BrainMock) Update(key string, q interface{}, modify func() error) error {
+	for i := 0; i < maxUpdateRetries; i++ {
+		b.mu.Lock()
+		b.expire(key)
+		val, ok := b.data[key]
+		version := b.versions[key]
+		b.mu.Unlock()
+
+		reset(q)
+		if ok {
+			if err := json.Unmarshal(val, q); err != nil {
+				return err
+			}
+		}
+
+		if err := modify(); err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(q)
+		if err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		if b.versions[key] != version {
+			// modified in the meantime, retry
+			b.mu.Unlock()
+			continue
+		}
+		b.data[key] = encoded
+		b.versions[key]++
+		delete(b.expiry, key)
+		b.mu.Unlock()
+		return nil
+	}
+	return ErrConflict
+}
+
+// Len returns the number of keys in the brain
+func (b *BrainMock) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key := range b.expiry {
+		b.expire(key)
+	}
+	return len(b.data)
+}
+
+func (b *BrainMock) Close() error {
 	return nil
 }
@@ -0,0 +1,196 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store is where the office closures are kept
+type Store interface {
+	Get(string, interface{}) error
+	Update(string, interface{}, func() error) error
+}
+
+// PatronDay is the day of the patron saint of the office town, San Giovanni for Florence
+var PatronDay = struct {
+	Month time.Month
+	Day   int
+	Name  string
+}{time.June, 24, "San Giovanni"}
+
+var holidays = []struct {
+	Month time.Month
+	Day   int
+	Name  string
+}{
+	{time.January, 1, "Capodanno"},
+	{time.January, 6, "Epifania"},
+	{time.April, 25, "Festa della Liberazione"},
+	{time.May, 1, "Festa del Lavoro"},
+	{time.June, 2, "Festa della Repubblica"},
+	{time.August, 15, "Ferragosto"},
+	{time.November, 1, "Ognissanti"},
+	{time.December, 8, "Immacolata"},
+	{time.December, 25, "Natale"},
+	{time.December, 26, "Santo Stefano"},
+}
+
+// Easter returns the Easter Sunday of the year, in the gregorian calendar
+func Easter(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// Holiday returns the name of the public holiday of the day, false if it's not a holiday
+func Holiday(day time.Time) (string, bool) {
+	for _, h := range holidays {
+		if day.Month() == h.Month && day.Day() == h.Day {
+			return h.Name, true
+		}
+	}
+	if day.Month() == PatronDay.Month && day.Day() == PatronDay.Day {
+		return PatronDay.Name, true
+	}
+
+	easter := Easter(day.Year())
+	if day.Month() == easter.Month() && day.Day() == easter.Day() {
+		return "Pasqua", true
+	}
+	monday := easter.AddDate(0, 0, 1)
+	if day.Month() == monday.Month() && day.Day() == monday.Day() {
+		return "Lunedì dell'Angelo", true
+	}
+	return "", false
+}
+
+// Closure is a period when the office is closed, dates are in the 2006-01-02 format
+type Closure struct {
+	From string
+	To   string
+	Note string `json:",omitempty"`
+}
+
+func formatDate(d string) string {
+	t, err := time.Parse("2006-01-02", d)
+	if err != nil {
+		return d
+	}
+	return t.Format("02/01/2006")
+}
+
+func (c *Closure) String() string {
+	s := formatDate(c.From)
+	if c.To != c.From {
+		s += " - " + formatDate(c.To)
+	}
+	if c.Note != "" {
+		s += " (" + c.Note + ")"
+	}
+	return s
+}
+
+// Contains returns true if the day is in the closure
+func (c *Closure) Contains(day time.Time) bool {
+	d := day.Format("2006-01-02")
+	return d >= c.From && d <= c.To
+}
+
+// ParseClosure parses a closure like "10/08-25/08" or "24/12" in the year of now, or in the next
+// one if it's already over. A period across the new year ends in the following year.
+func ParseClosure(s string, now time.Time) (Closure, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+
+	var dates [2]time.Time
+	for i, p := range parts {
+		d, err := time.Parse("02/01", strings.TrimSpace(p))
+		if err != nil {
+			return Closure{}, fmt.Errorf("Data '%s' non valida, usa il formato gg/mm", strings.TrimSpace(p))
+		}
+		dates[i] = time.Date(now.Year(), d.Month(), d.Day(), 0, 0, 0, 0, now.Location())
+	}
+	if dates[1].Before(dates[0]) {
+		dates[1] = dates[1].AddDate(1, 0, 0)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if dates[1].Before(today) {
+		dates[0] = dates[0].AddDate(1, 0, 0)
+		dates[1] = dates[1].AddDate(1, 0, 0)
+	}
+	return Closure{From: dates[0].Format("2006-01-02"), To: dates[1].Format("2006-01-02")}, nil
+}
+
+// LoadClosures returns the office closures, sorted by date
+func LoadClosures(store Store) []Closure {
+	var closures []Closure
+	store.Get("closures", &closures)
+	return closures
+}
+
+// UpdateClosures atomically modifies the closures, dropping the ones already over
+func UpdateClosures(store Store, now time.Time, modify func(c *[]Closure) error) error {
+	var closures []Closure
+	return store.Update("closures", &closures, func() error {
+		if err := modify(&closures); err != nil {
+			return err
+		}
+
+		today := now.Format("2006-01-02")
+		var r []Closure
+		for _, c := range closures {
+			if c.To >= today {
+				r = append(r, c)
+			}
+		}
+		sort.Slice(r, func(i, j int) bool {
+			return r[i].From < r[j].From
+		})
+		closures = r
+		return nil
+	})
+}
+
+// IsWorkingDay returns true if the office is open on the day, otherwise the reason why it's closed
+func IsWorkingDay(store Store, day time.Time) (bool, string) {
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false, "fine settimana"
+	}
+	if name, ok := Holiday(day); ok {
+		return false, name
+	}
+	for _, c := range LoadClosures(store) {
+		if c.Contains(day) {
+			return false, "chiusura " + c.String()
+		}
+	}
+	return true, ""
+}
+
+// NextHolidays returns the public holidays in the given number of days from the day
+func NextHolidays(day time.Time, days int) []string {
+	var r []string
+	for i := 0; i < days; i++ {
+		d := day.AddDate(0, 0, i)
+		if name, ok := Holiday(d); ok {
+			r = append(r, fmt.Sprintf("%s: %s", d.Format("02/01/2006"), name))
+		}
+	}
+	return r
+}
@@ -0,0 +1,88 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+}
+
+func TestEaster(t *testing.T) {
+	for y, want := range map[int]string{2019: "04-21", 2024: "03-31", 2025: "04-20", 2026: "04-05", 2038: "04-25"} {
+		if got := Easter(y).Format("01-02"); got != want {
+			t.Fatalf("Error, Easter %d is %s, got %s", y, want, got)
+		}
+	}
+}
+
+func TestHoliday(t *testing.T) {
+	for _, d := range []time.Time{date(2026, 4, 6), date(2026, 6, 24), date(2026, 12, 8), date(2025, 4, 21)} {
+		if _, ok := Holiday(d); !ok {
+			t.Fatalf("Error, %s should be a holiday", d.Format("02/01/2006"))
+		}
+	}
+	if name, _ := Holiday(date(2026, 4, 6)); name != "Lunedì dell'Angelo" {
+		t.Fatalf("Error, wrong holiday %s", name)
+	}
+	for _, d := range []time.Time{date(2026, 4, 7), date(2026, 10, 19), date(2025, 4, 6)} {
+		if name, ok := Holiday(d); ok {
+			t.Fatalf("Error, %s should not be a holiday, got %s", d.Format("02/01/2006"), name)
+		}
+	}
+}
+
+func TestParseClosure(t *testing.T) {
+	now := date(2026, 10, 19)
+	for s, want := range map[string]string{
+		"10/08-25/08": "2027-08-10 2027-08-25",
+		"24/12":       "2026-12-24 2026-12-24",
+		"24/12-06/01": "2026-12-24 2027-01-06",
+		"19/10-20/10": "2026-10-19 2026-10-20",
+	} {
+		c, err := ParseClosure(s, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.From + " " + c.To; got != want {
+			t.Fatalf("Error, '%s' should be %s, got %s", s, want, got)
+		}
+	}
+	if _, err := ParseClosure("32/08", now); err == nil {
+		t.Fatal("Error, invalid date accepted")
+	}
+}
+
+func TestIsWorkingDay(t *testing.T) {
+	b := brain.NewBrainMock()
+	now := date(2026, 7, 1)
+
+	err := UpdateClosures(b, now, func(c *[]Closure) error {
+		august, _ := ParseClosure("10/08-25/08", now)
+		old := Closure{From: "2026-01-02", To: "2026-01-02"}
+		*c = append(*c, august, old)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the closures already over are dropped
+	if c := LoadClosures(b); len(c) != 1 || c[0].String() != "10/08/2026 - 25/08/2026" {
+		t.Fatalf("Error, wrong closures %+v", c)
+	}
+
+	for d, want := range map[time.Time]bool{
+		date(2026, 8, 12):  false,
+		date(2026, 8, 26):  true,
+		date(2026, 10, 17): false, // saturday
+		date(2026, 6, 24):  false,
+		date(2026, 10, 19): true,
+	} {
+		if ok, why := IsWorkingDay(b, d); ok != want {
+			t.Fatalf("Error, %s working day should be %v (%s)", d.Format("02/01/2006"), want, why)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markbates/grift/grift"
+	"github.com/robfig/cron"
+)
+
+// DefaultGrace is how late a run missed while the scheduler was down can still be executed
+const DefaultGrace = 30 * time.Minute
+
+// reloadInterval is how often the entries are reloaded from the store
+const reloadInterval = time.Minute
+
+// stopTimeout is how long Stop waits for the running tasks
+const stopTimeout = 30 * time.Second
+
+// Store is where the scheduler keeps the entries and their last runs
+type Store interface {
+	Get(string, interface{}) error
+	Update(string, interface{}, func() error) error
+}
+
+// Runner executes a task with its arguments
+type Runner func(task string, args []string) error
+
+// Namespace is the grift namespace of the tasks that can be scheduled
+const Namespace = "tinabot"
+
+// RunTask runs a task of the grift namespace, a panic of the task is returned as an error
+func RunTask(task string, args []string) error {
+	return protect(func(task string, args []string) error {
+		name := Namespace + ":" + task
+		ctx := grift.NewContext(name)
+		ctx.Args = args
+		return grift.Run(name, ctx)
+	}, task, args)
+}
+
+// protect runs the task turning a panic into an error, so that a task can't take down the process
+func protect(run Runner, task string, args []string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task %s panicked: %v", task, r)
+		}
+	}()
+	return run(task, args)
+}
+
+// TaskExists returns true if the task is registered in the grift namespace
+func TaskExists(task string) bool {
+	for _, name := range grift.List() {
+		if name == Namespace+":"+task {
+			return true
+		}
+	}
+	return false
+}
+
+// Entry is a scheduled task
+type Entry struct {
+	ID         int
+	Spec       string
+	Task       string
+	Args       []string `json:",omitempty"`
+	Enabled    bool
+	CreatedBy  string    `json:",omitempty"`
+	LastRun    time.Time `json:",omitempty"`
+	LastResult string    `json:",omitempty"`
+	schedule   cron.Schedule
+}
+
+// ParseEntry parses a "<cron spec>;<task> [<args>]" string into an enabled entry
+func ParseEntry(s string) (Entry, error) {
+	r := strings.SplitN(s, ";", 2)
+	if len(r) < 2 {
+		return Entry{}, fmt.Errorf("malformed cron string: %s", s)
+	}
+	args := strings.Fields(r[1])
+	if len(args) < 1 {
+		return Entry{}, fmt.Errorf("no task specified: %s", s)
+	}
+	e := Entry{Spec: strings.TrimSpace(r[0]), Task: args[0], Args: args[1:], Enabled: true}
+	return e, e.Parse()
+}
+
+// Parse parses the cron spec of the entry
+func (e *Entry) Parse() error {
+	sched, err := cron.ParseStandard(e.Spec)
+	if err != nil {
+		return err
+	}
+	e.schedule = sched
+	return nil
+}
+
+// Next returns the first run of the entry after t
+func (e *Entry) Next(t time.Time) time.Time {
+	return e.schedule.Next(t)
+}
+
+// Command returns the entry in the "<cron spec>;<task> [<args>]" format
+func (e *Entry) Command() string {
+	return e.Spec + ";" + strings.Join(append([]string{e.Task}, e.Args...), " ")
+}
+
+func (e *Entry) String() string {
+	s := fmt.Sprintf("%d - %s", e.ID, e.Command())
+	if !e.Enabled {
+		s += " (disattivato)"
+	}
+	if !e.LastRun.IsZero() {
+		s += fmt.Sprintf(" [%s: %s]", e.LastRun.Format("02/01 15:04"), e.LastResult)
+	}
+	return s
+}
+
+// Entries is the list of the scheduled tasks stored in the "cron" key. The legacy format,
+// a list of "<cron spec>;<task> [<args>]" strings, is converted when loaded.
+type Entries []Entry
+
+func (es *Entries) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*es = nil
+	for i, r := range raw {
+		var e Entry
+		var legacy string
+		if json.Unmarshal(r, &legacy) == nil {
+			e, _ = ParseEntry(legacy)
+			if e.Task == "" {
+				e = Entry{Spec: legacy}
+			}
+			e.ID = i + 1
+		} else if err := json.Unmarshal(r, &e); err != nil {
+			return err
+		}
+		*es = append(*es, e)
+	}
+	return nil
+}
+
+// Find returns the index of the entry with the given ID, -1 if not found
+func (es Entries) Find(id int) int {
+	for i, e := range es {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// NextID returns the ID for a new entry
+func (es Entries) NextID() int {
+	id := 0
+	for _, e := range es {
+		if e.ID > id {
+			id = e.ID
+		}
+	}
+	return id + 1
+}
+
+// LoadEntries loads the entries from the "cron" key of the store
+func LoadEntries(store Store) Entries {
+	var entries Entries
+	store.Get("cron", &entries)
+
+	for i := range entries {
+		if err := entries[i].Parse(); err != nil {
+			log.Printf("Invalid cron entry %d: %s", entries[i].ID, err)
+		}
+	}
+	return entries
+}
+
+// UpdateEntries atomically modifies the entries, see Brain.Update
+func UpdateEntries(store Store, modify func(es *Entries) error) error {
+	var entries Entries
+	return store.Update("cron", &entries, func() error {
+		return modify(&entries)
+	})
+}
+
+// Record saves the result of a run of the entry
+func Record(store Store, id int, t time.Time, result error) error {
+	return UpdateEntries(store, func(es *Entries) error {
+		i := es.Find(id)
+		if i < 0 {
+			return nil
+		}
+		(*es)[i].LastRun = t
+		(*es)[i].LastResult = "ok"
+		if result != nil {
+			(*es)[i].LastResult = result.Error()
+		}
+		return nil
+	})
+}
+
+// active returns the entries to run
+func active(entries Entries) []Entry {
+	var r []Entry
+	for _, e := range entries {
+		if e.Enabled && e.schedule != nil {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// claim records that the run of the entry scheduled at t is being executed.
+// It returns false if that run, or a later one, has already been executed.
+func claim(store Store, e *Entry, t time.Time) (bool, error) {
+	var runs map[string]time.Time
+	key := strconv.Itoa(e.ID)
+	claimed := false
+	err := store.Update("cron_runs", &runs, func() error {
+		claimed = false
+		if last, ok := runs[key]; ok && !last.Before(t) {
+			return nil
+		}
+		if runs == nil {
+			runs = make(map[string]time.Time)
+		}
+		runs[key] = t
+		claimed = true
+		return nil
+	})
+	return claimed, err
+}
+
+// lastRun returns the latest run of the entry scheduled in (now-grace, now], false if none
+func lastRun(e *Entry, now time.Time, grace time.Duration) (time.Time, bool) {
+	var last time.Time
+	found := false
+	for t := e.Next(now.Add(-grace)); !t.After(now); t = e.Next(t) {
+		last = t
+		found = true
+	}
+	return last, found
+}
+
+// Scheduler runs the tasks of the entries at their time. Each run is executed at most once,
+// even with more schedulers sharing the same store.
+type Scheduler struct {
+	// WorkingDay, if set, tells if the tasks must run on the day; the runs of the other days are skipped
+	WorkingDay func(day time.Time) bool
+
+	store Store
+	run   Runner
+	loc   *time.Location
+	grace time.Duration
+
+	mu      sync.Mutex
+	cron    *cron.Cron
+	raw     []string
+	stopped bool
+	wg      sync.WaitGroup
+	done    chan struct{}
+}
+
+// New returns a scheduler running the tasks in the given location
+func New(store Store, run Runner, loc *time.Location) *Scheduler {
+	return &Scheduler{
+		store: store,
+		run:   run,
+		loc:   loc,
+		grace: DefaultGrace,
+		done:  make(chan struct{}),
+	}
+}
+
+// execute runs the entry for its run scheduled at t, unless it has already been executed
+func (s *Scheduler) execute(e Entry, t time.Time) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	if s.WorkingDay != nil && !s.WorkingDay(t) {
+		log.Printf("Skipping cron %s, not a working day", e.String())
+		return
+	}
+
+	ok, err := claim(s.store, &e, t)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	log.Printf("Executing cron %s (scheduled at %s)", e.String(), t.Format("02/01 15:04"))
+	err = protect(s.run, e.Task, e.Args)
+	if err != nil {
+		log.Println(err)
+	}
+	if err := Record(s.store, e.ID, t, err); err != nil {
+		log.Println(err)
+	}
+}
+
+// CatchUp executes the latest run of each entry scheduled in the grace period before now,
+// if it has not been executed yet
+func (s *Scheduler) CatchUp(now time.Time, grace time.Duration) {
+	now = now.In(s.loc)
+	for _, e := range active(LoadEntries(s.store)) {
+		if t, ok := lastRun(&e, now, grace); ok {
+			s.execute(e, t)
+		}
+	}
+}
+
+// reload rebuilds the cron jobs if the entries have changed
+func (s *Scheduler) reload() {
+	entries := active(LoadEntries(s.store))
+	var raw []string
+	for _, e := range entries {
+		raw = append(raw, strconv.Itoa(e.ID)+" "+e.Command())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped || (s.cron != nil && strings.Join(raw, "\n") == strings.Join(s.raw, "\n")) {
+		return
+	}
+
+	c := cron.NewWithLocation(s.loc)
+	for _, e := range entries {
+		e := e
+		c.Schedule(e.schedule, cron.FuncJob(func() {
+			s.execute(e, time.Now().In(s.loc).Truncate(time.Minute))
+		}))
+	}
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+	c.Start()
+	s.cron = c
+	s.raw = raw
+	log.Printf("Scheduler loaded %d cron entries", len(entries))
+}
+
+// Start catches up the runs missed while the scheduler was down and starts running the
+// entries, reloading them periodically
+func (s *Scheduler) Start() {
+	go s.CatchUp(time.Now(), s.grace)
+	s.reload()
+
+	go func() {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.reload()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduler and waits for the running tasks to complete
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.done)
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(stopTimeout):
+		log.Println("Scheduler stopped with tasks still running")
+	}
+}
@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+)
+
+func TestParseEntry(t *testing.T) {
+	e, err := ParseEntry("50 11 * * 1-5;post  #pranzo -m ciao")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Spec != "50 11 * * 1-5" || e.Task != "post" || strings.Join(e.Args, " ") != "#pranzo -m ciao" {
+		t.Fatalf("Error, wrong entry %+v", e)
+	}
+
+	for _, s := range []string{"50 11 * * 1-5", "50 11 * * 1-5; ", "61 11 * * *;post"} {
+		if _, err := ParseEntry(s); err == nil {
+			t.Fatalf("Error, '%s' should not be valid", s)
+		}
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	b := brain.NewBrainMock()
+	b.Set("cron", []string{"0 12 * * *;a", "*/10 * * * *;b x", "bad"})
+
+	var mu sync.Mutex
+	var runs []string
+	s := New(b, func(task string, args []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs = append(runs, strings.Join(append([]string{task}, args...), " "))
+		return nil
+	}, time.UTC)
+
+	now := time.Date(2026, 10, 19, 12, 25, 0, 0, time.UTC)
+	s.CatchUp(now, DefaultGrace)
+	if strings.Join(runs, ",") != "a,b x" {
+		t.Fatalf("Error, wrong runs %v", runs)
+	}
+
+	// each run is executed at most once
+	runs = nil
+	s.CatchUp(now.Add(time.Minute), DefaultGrace)
+	if len(runs) != 0 {
+		t.Fatalf("Error, runs executed twice %v", runs)
+	}
+
+	// only the latest run of the grace period is executed, older ones are skipped
+	s.CatchUp(now.Add(time.Hour), DefaultGrace)
+	if strings.Join(runs, ",") != "b x" {
+		t.Fatalf("Error, wrong runs %v", runs)
+	}
+
+	// a stopped scheduler does not run anything
+	runs = nil
+	s.Stop()
+	s.CatchUp(now.Add(2*time.Hour), DefaultGrace)
+	if len(runs) != 0 {
+		t.Fatalf("Error, stopped scheduler executed %v", runs)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	b := brain.NewBrainMock()
+	e, _ := ParseEntry("0 12 * * *;a")
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := claim(b, &e, at); ok {
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Fatalf("Error, run claimed %d times", claimed)
+	}
+
+	if ok, _ := claim(b, &e, at.Add(-24*time.Hour)); ok {
+		t.Fatal("Error, older run claimed")
+	}
+	if ok, _ := claim(b, &e, at.Add(24*time.Hour)); !ok {
+		t.Fatal("Error, newer run not claimed")
+	}
+}
+
+func TestEntries(t *testing.T) {
+	b := brain.NewBrainMock()
+	b.Set("cron", []string{"0 12 * * *;a", "bad"})
+
+	// the legacy format is converted on load
+	entries := LoadEntries(b)
+	if len(entries) != 2 || entries[0].ID != 1 || entries[0].Task != "a" || !entries[0].Enabled || entries[1].ID != 2 {
+		t.Fatalf("Error, wrong entries %+v", entries)
+	}
+
+	err := UpdateEntries(b, func(es *Entries) error {
+		e, _ := ParseEntry("30 11 * * 1-5;post #pranzo ciao")
+		e.ID = es.NextID()
+		(*es)[0].Enabled = false
+		*es = append(*es, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Record(b, 3, time.Date(2026, 10, 19, 11, 30, 0, 0, time.UTC), nil)
+
+	entries = LoadEntries(b)
+	if len(entries) != 3 || entries.Find(3) != 2 {
+		t.Fatalf("Error, wrong entries %+v", entries)
+	}
+	if s := entries[2].String(); s != "3 - 30 11 * * 1-5;post #pranzo ciao [19/10 11:30: ok]" {
+		t.Fatalf("Error, wrong entry '%s'", s)
+	}
+	if s := entries[0].String(); s != "1 - 0 12 * * *;a (disattivato)" {
+		t.Fatalf("Error, wrong entry '%s'", s)
+	}
+
+	// only the enabled and valid entries are run
+	if a := active(entries); len(a) != 1 || a[0].ID != 3 {
+		t.Fatalf("Error, wrong active entries %+v", a)
+	}
+}
+
+func TestWorkingDay(t *testing.T) {
+	b := brain.NewBrainMock()
+	b.Set("cron", []string{"0 12 * * *;a"})
+
+	runs := 0
+	s := New(b, func(task string, args []string) error {
+		runs++
+		return nil
+	}, time.UTC)
+	s.WorkingDay = func(day time.Time) bool {
+		return day.Weekday() != time.Sunday
+	}
+
+	s.CatchUp(time.Date(2026, 10, 18, 12, 5, 0, 0, time.UTC), DefaultGrace)
+	if runs != 0 {
+		t.Fatal("Error, task run on a non working day")
+	}
+	s.CatchUp(time.Date(2026, 10, 19, 12, 5, 0, 0, time.UTC), DefaultGrace)
+	if runs != 1 {
+		t.Fatal("Error, task not run on a working day")
+	}
+}
+
+func TestPanic(t *testing.T) {
+	b := brain.NewBrainMock()
+	b.Set("cron", []string{"0 12 * * *;a"})
+
+	s := New(b, func(task string, args []string) error {
+		panic("boom")
+	}, time.UTC)
+
+	s.CatchUp(time.Date(2026, 10, 19, 12, 5, 0, 0, time.UTC), DefaultGrace)
+	e := LoadEntries(b)[0]
+	if !strings.Contains(e.LastResult, "boom") {
+		t.Fatalf("Error, panic not recorded: %+v", e)
+	}
+}
@@ -0,0 +1,151 @@
+package slackbot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// ConversationTTL is how long the bot waits for the answer to a question
+const ConversationTTL = 5 * time.Minute
+
+// Store is where the bot keeps the conversations state, so that it survives across requests.
+// Each conversation is a key of its own, removed by the store when the conversation expires.
+type Store interface {
+	Get(string, interface{}) error
+	SetWithExpiry(string, interface{}, time.Duration) error
+	Delete(string) error
+}
+
+// Conversation is a question asked by the bot to a user, waiting for the answer in the same channel
+type Conversation struct {
+	Action  string // name of the ConversationAction handling the answer
+	Options []string
+	Data    map[string]string
+	Expiry  time.Time
+}
+
+// ConversationAction handles the answer to a pending conversation.
+// It returns false if the message is not an answer and must be handled as a normal command.
+type ConversationAction func(*Bot, *BotMsg, *slack.User, *Conversation, string) bool
+
+// Choice returns the index of the option chosen by the user with a 1-based number
+func (c *Conversation) Choice(text string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || n < 1 || n > len(c.Options) {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+func conversationKey(channel, user string) string {
+	return "conversations:" + channel + ":" + user
+}
+
+// SetStore sets the store used to keep the conversations
+func (bot *Bot) SetStore(store Store) {
+	bot.store = store
+}
+
+// RespondToConversation registers the action handling the answers to the conversations with the given name
+func (bot *Bot) RespondToConversation(name string, action ConversationAction) {
+	bot.conversations[name] = action
+}
+
+// loadConversation returns the pending conversation of the user in the channel, if any
+func (bot *Bot) loadConversation(channel, user string) (Conversation, bool) {
+	var conv Conversation
+	if bot.store == nil || bot.store.Get(conversationKey(channel, user), &conv) != nil {
+		return Conversation{}, false
+	}
+	if conv.Action == "" || time.Now().After(conv.Expiry) {
+		return Conversation{}, false
+	}
+	return conv, true
+}
+
+// saveConversation stores the pending conversation of the user in the channel until it expires
+func (bot *Bot) saveConversation(channel, user string, conv Conversation) {
+	if bot.store == nil {
+		log.Println("No store set, conversation lost")
+		return
+	}
+	ttl := time.Until(conv.Expiry)
+	if ttl <= 0 {
+		return
+	}
+	if err := bot.store.SetWithExpiry(conversationKey(channel, user), conv, ttl); err != nil {
+		log.Println(err)
+	}
+}
+
+// Ask posts the question followed by the numbered options, and waits for the user answer
+// in the same channel. The answer is handled by the ConversationAction named conv.Action.
+func (bot *Bot) Ask(channel, user, question string, conv Conversation) {
+	var lines []string
+	for i, o := range conv.Options {
+		lines = append(lines, fmt.Sprintf("*%d* - %s", i+1, o))
+	}
+	if len(lines) > 0 {
+		question += "\n" + strings.Join(lines, "\n")
+	}
+
+	if conv.Expiry.IsZero() {
+		conv.Expiry = time.Now().Add(ConversationTTL)
+	}
+
+	bot.saveConversation(channel, user, conv)
+	bot.Message(channel, question)
+}
+
+// EndConversation removes the pending conversation of the user in the channel, if any
+func (bot *Bot) EndConversation(channel, user string) {
+	if bot.store == nil {
+		return
+	}
+	if err := bot.store.Delete(conversationKey(channel, user)); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleConversation passes the message to the pending conversation of the user, if any.
+// It returns true if the message has been handled.
+func (bot *Bot) handleConversation(msg *BotMsg) bool {
+	if bot.store == nil || msg.User == "" || msg.User == bot.UserID {
+		return false
+	}
+
+	conv, ok := bot.loadConversation(msg.Channel, msg.User)
+	if !ok {
+		return false
+	}
+
+	action, ok := bot.conversations[conv.Action]
+	if !ok {
+		log.Println("No action registered for conversation " + conv.Action)
+		return false
+	}
+
+	user, err := bot.Client.GetUserInfo(msg.User)
+	if err != nil {
+		log.Println(err.Error())
+		return false
+	}
+
+	// The conversation is removed before calling the action, so that it can ask a new question
+	bot.EndConversation(msg.Channel, msg.User)
+
+	if action(bot, msg, user, &conv, bot.cleanupMsg(msg.Text)) {
+		return true
+	}
+
+	// Not an answer, keep waiting
+	if _, ok := bot.loadConversation(msg.Channel, msg.User); !ok {
+		bot.saveConversation(msg.Channel, msg.User, conv)
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package slackbot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+)
+
+func TestConversationChoice(t *testing.T) {
+	c := Conversation{Options: []string{"a", "b", "c"}}
+
+	if n, ok := c.Choice(" 2 "); !ok || n != 1 {
+		t.Fatalf("Error, wanted 1, got %d, %v", n, ok)
+	}
+
+	for _, s := range []string{"0", "4", "b", ""} {
+		if _, ok := c.Choice(s); ok {
+			t.Fatalf("Error, '%s' should not be a valid choice", s)
+		}
+	}
+}
+
+func TestConversationExpiry(t *testing.T) {
+	b := brain.NewBrainMock()
+	bot := New("BOT", nil)
+	bot.SetStore(b)
+
+	bot.saveConversation("C1", "U1", Conversation{Action: "a", Expiry: time.Now().Add(time.Minute)})
+	bot.saveConversation("C1", "U2", Conversation{Action: "a", Expiry: time.Now().Add(-time.Minute)})
+	bot.saveConversation("C2", "U1", Conversation{Action: "b", Expiry: time.Now().Add(20 * time.Millisecond)})
+	b.Set(conversationKey("C2", "U2"), Conversation{Action: "a", Expiry: time.Now().Add(-time.Minute)})
+
+	if c, ok := bot.loadConversation("C1", "U1"); !ok || c.Action != "a" {
+		t.Fatal("Error, pending conversation not found")
+	}
+	if _, ok := bot.loadConversation("C1", "U2"); ok {
+		t.Fatal("Error, expired conversation found")
+	}
+	if _, ok := bot.loadConversation("C2", "U2"); ok {
+		t.Fatal("Error, expired conversation found")
+	}
+	if c, ok := bot.loadConversation("C2", "U1"); !ok || c.Action != "b" {
+		t.Fatal("Error, conversations of other channels changed")
+	}
+
+	// the store removes the conversation when it expires
+	time.Sleep(30 * time.Millisecond)
+	if b.Len() != 2 {
+		t.Fatalf("Error, wanted 2 keys, got %d", b.Len())
+	}
+
+	bot.EndConversation("C1", "U1")
+	if _, ok := bot.loadConversation("C1", "U1"); ok {
+		t.Fatal("Error, conversation not ended")
+	}
+}
@@ -1,6 +1,7 @@
 package slackbot
 
 import (
+	"io"
 	"log"
 	"regexp"
 	"strings"
@@ -17,21 +18,29 @@ type BotMsg struct {
 type SimpleAction func(*Bot, *BotMsg, *slack.User)
 type Action func(*Bot, *BotMsg, *slack.User, ...string)
 
+// InteractiveAction is called when a user clicks a button of a message sent with MessageWithButtons
+type InteractiveAction func(*Bot, *slack.InteractionCallback)
+
 type Bot struct {
 	UserID string
 
 	Client *slack.Client
 
-	actions map[*regexp.Regexp]Action
-	defact  SimpleAction
+	actions       map[*regexp.Regexp]Action
+	defact        SimpleAction
+	interactive   map[string]InteractiveAction
+	conversations map[string]ConversationAction
+	store         Store
 }
 
 func New(botID string, api *slack.Client) *Bot {
 
 	bot := &Bot{
-		UserID:  botID,
-		Client:  api,
-		actions: make(map[*regexp.Regexp]Action),
+		UserID:        botID,
+		Client:        api,
+		actions:       make(map[*regexp.Regexp]Action),
+		interactive:   make(map[string]InteractiveAction),
+		conversations: make(map[string]ConversationAction),
 	}
 
 	return bot
@@ -41,6 +50,11 @@ func (bot *Bot) RespondTo(match string, action Action) {
 	bot.actions[regexp.MustCompile(match)] = action
 }
 
+// RespondToAction registers the action to be called for button clicks with the given callback ID
+func (bot *Bot) RespondToAction(callbackID string, action InteractiveAction) {
+	bot.interactive[callbackID] = action
+}
+
 func (bot *Bot) DefaultResponse(action SimpleAction) {
 	bot.defact = action
 }
@@ -49,6 +63,33 @@ func (bot *Bot) Message(channel string, msg string) {
 	bot.Client.PostMessage(channel, slack.MsgOptionText(msg, false))
 }
 
+// MessageWithButtons posts a message with a row of buttons; clicks are dispatched to
+// the InteractiveAction registered with the same callbackID
+func (bot *Bot) MessageWithButtons(channel, msg, callbackID string, buttons ...slack.AttachmentAction) {
+	for i := range buttons {
+		if buttons[i].Type == "" {
+			buttons[i].Type = "button"
+		}
+	}
+
+	bot.Client.PostMessage(channel, slack.MsgOptionText(msg, false), slack.MsgOptionAttachments(slack.Attachment{
+		Fallback:   msg,
+		CallbackID: callbackID,
+		Actions:    buttons,
+	}))
+}
+
+// Upload uploads a file to the channel, with an optional comment
+func (bot *Bot) Upload(channel, filename, comment string, r io.Reader) error {
+	_, err := bot.Client.UploadFile(slack.FileUploadParameters{
+		Reader:         r,
+		Filename:       filename,
+		InitialComment: comment,
+		Channels:       []string{channel},
+	})
+	return err
+}
+
 func (bot *Bot) validMessage(msg *BotMsg) bool {
 	return msg.User != bot.UserID &&
 		(strings.HasPrefix(msg.Text, "<@"+bot.UserID+">") || strings.HasPrefix(msg.Channel, "D"))
@@ -60,6 +101,12 @@ func (bot *Bot) cleanupMsg(msg string) string {
 
 func (bot *Bot) HandleMsg(channel, username, text string) {
 	msg := &BotMsg{channel, username, text}
+
+	// Answers to a pending question don't need to mention the bot
+	if bot.handleConversation(msg) {
+		return
+	}
+
 	if !bot.validMessage(msg) {
 		return
 	}
@@ -83,3 +130,14 @@ func (bot *Bot) HandleMsg(channel, username, text string) {
 		bot.defact(bot, msg, user)
 	}
 }
+
+// HandleAction dispatches a button click to the registered InteractiveAction
+func (bot *Bot) HandleAction(cb *slack.InteractionCallback) {
+	action, ok := bot.interactive[cb.CallbackID]
+	if !ok {
+		log.Println("No action registered for callback " + cb.CallbackID)
+		return
+	}
+
+	action(bot, cb)
+}
@@ -0,0 +1,326 @@
+package tinabot
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// AbsencePeriod is a period when the user is not in the office, dates are in the 2006-01-02 format
+type AbsencePeriod struct {
+	From string
+	To   string
+}
+
+func (p *AbsencePeriod) String() string {
+	from, _ := time.Parse("2006-01-02", p.From)
+	to, _ := time.Parse("2006-01-02", p.To)
+	if p.From == p.To {
+		return from.Format("02/01")
+	}
+	return fmt.Sprintf("dal %s al %s", from.Format("02/01"), to.Format("02/01"))
+}
+
+// Absence tells when a user is not in the office: the absence periods and the remote work days
+type Absence struct {
+	Periods  []AbsencePeriod `json:",omitempty"`
+	Remote   int             `json:",omitempty"` // week days of remote work, bit 0 is sunday
+	MarkNone bool            `json:",omitempty"` // mark "Niente" on the lunch sheet when absent
+}
+
+// Absent returns true if the user is not in the office on the day
+func (a *Absence) Absent(day time.Time) bool {
+	if a.Remote&(1<<uint(day.Weekday())) != 0 {
+		return true
+	}
+	d := day.Format("2006-01-02")
+	for _, p := range a.Periods {
+		if d >= p.From && d <= p.To {
+			return true
+		}
+	}
+	return false
+}
+
+// Format returns the description of the absence, with the code marked when the user is absent
+func (a *Absence) Format(codes *MarkCodes) string {
+	var r []string
+	if len(a.Periods) > 0 {
+		var periods []string
+		for _, p := range a.Periods {
+			periods = append(periods, p.String())
+		}
+		r = append(r, "Assente: "+strings.Join(periods, ", "))
+	}
+	if a.Remote != 0 {
+		r = append(r, "Lavoro da remoto: "+formatWeekMask(a.Remote))
+	}
+	if a.MarkNone {
+		r = append(r, "Quando sei assente segno `"+codes.Nothing+"` sul foglio dei pranzi")
+	}
+	if len(r) == 0 {
+		return "Nessuna assenza impostata"
+	}
+	return strings.Join(r, "\n")
+}
+
+// LoadAbsences returns the absences by user ID
+func LoadAbsences(brain DataStore) map[string]Absence {
+	absences := make(map[string]Absence)
+	brain.Get("absences", &absences)
+	return absences
+}
+
+// updateAbsence atomically modifies the absence of the user, dropping the periods already over
+func updateAbsence(brain DataStore, userID string, now time.Time, modify func(a *Absence)) (Absence, error) {
+	var absences map[string]Absence
+	var absence Absence
+	err := brain.Update("absences", &absences, func() error {
+		if absences == nil {
+			absences = make(map[string]Absence)
+		}
+		a := absences[userID]
+		modify(&a)
+
+		today := now.Format("2006-01-02")
+		var periods []AbsencePeriod
+		for _, p := range a.Periods {
+			if p.To >= today {
+				periods = append(periods, p)
+			}
+		}
+		sort.Slice(periods, func(i, j int) bool {
+			return periods[i].From < periods[j].From
+		})
+		a.Periods = periods
+
+		if len(a.Periods) == 0 && a.Remote == 0 && !a.MarkNone {
+			delete(absences, userID)
+		} else {
+			absences[userID] = a
+		}
+		absence = a
+		return nil
+	})
+	return absence, err
+}
+
+// IsAbsent returns true if the user is not in the office on the day
+func IsAbsent(brain DataStore, userID string, day time.Time) bool {
+	a, ok := LoadAbsences(brain)[userID]
+	return ok && a.Absent(day)
+}
+
+// AbsentToMark returns the IDs of the users absent on the day who want "Niente" on the lunch sheet
+func AbsentToMark(brain DataStore, day time.Time) []string {
+	var r []string
+	for id, a := range LoadAbsences(brain) {
+		if a.MarkNone && a.Absent(day) {
+			r = append(r, id)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// NotOrdered returns the IDs of the users with the reminder active on the day who have not ordered
+// yet, skipping the absent ones
+func NotOrdered(brain DataStore, order *Order, day time.Time) []string {
+	var remind map[string]int
+	brain.Get("remind", &remind)
+
+	ordered := make(map[string]bool)
+	for u := range order.Users {
+		ordered[u.ID] = true
+	}
+
+	absences := LoadAbsences(brain)
+	var r []string
+	for id, mask := range remind {
+		if mask&(1<<uint(day.Weekday())) == 0 || ordered[id] {
+			continue
+		}
+		if a, ok := absences[id]; ok && a.Absent(day) {
+			continue
+		}
+		r = append(r, id)
+	}
+	sort.Strings(r)
+	return r
+}
+
+var absenceRangeRe = regexp.MustCompile(`^(?i)dal\s+(\d{1,2}(?:/\d{1,2})?)\s+al\s+(\d{1,2}(?:/\d{1,2})?)$`)
+
+// parseAbsenceDay parses a day like "3" (in the month of now) or "3/11"
+func parseAbsenceDay(s string, now time.Time) (time.Time, error) {
+	parts := strings.Split(s, "/")
+	d, _ := strconv.Atoi(parts[0])
+	m := int(now.Month())
+	if len(parts) == 2 {
+		m, _ = strconv.Atoi(parts[1])
+	}
+	t := time.Date(now.Year(), time.Month(m), d, 0, 0, 0, 0, now.Location())
+	if d < 1 || m < 1 || m > 12 || t.Day() != d {
+		return t, fmt.Errorf("Giorno '%s' non valido", s)
+	}
+	return t, nil
+}
+
+// parseAbsencePeriod parses "dal <giorno> al <giorno>", moving it to the next month (or year)
+// if it's already over
+func parseAbsencePeriod(s string, now time.Time) (AbsencePeriod, error) {
+	m := absenceRangeRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return AbsencePeriod{}, fmt.Errorf("Periodo '%s' non valido", s)
+	}
+	from, err := parseAbsenceDay(m[1], now)
+	if err != nil {
+		return AbsencePeriod{}, err
+	}
+	to, err := parseAbsenceDay(m[2], now)
+	if err != nil {
+		return AbsencePeriod{}, err
+	}
+
+	if to.Before(from) {
+		if strings.Contains(m[2], "/") {
+			to = to.AddDate(1, 0, 0)
+		} else {
+			to = to.AddDate(0, 1, 0)
+		}
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if to.Before(today) {
+		if strings.Contains(m[1], "/") {
+			from, to = from.AddDate(1, 0, 0), to.AddDate(1, 0, 0)
+		} else {
+			from, to = from.AddDate(0, 1, 0), to.AddDate(0, 1, 0)
+		}
+	}
+	return AbsencePeriod{from.Format("2006-01-02"), to.Format("2006-01-02")}, nil
+}
+
+// Today sets the user absent or present today: "oggi non ci sono", "oggi ci sono"
+func (t *TinaBot) Today(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	absent := strings.TrimSpace(args[1]) != ""
+	t.setAbsence(msg, user, func(a *Absence, today string) {
+		var periods []AbsencePeriod
+		for _, p := range a.Periods {
+			if p.From <= today && p.To >= today {
+				// split the period around today
+				day, _ := time.Parse("2006-01-02", today)
+				if p.From < today {
+					periods = append(periods, AbsencePeriod{p.From, day.AddDate(0, 0, -1).Format("2006-01-02")})
+				}
+				if p.To > today {
+					periods = append(periods, AbsencePeriod{day.AddDate(0, 0, 1).Format("2006-01-02"), p.To})
+				}
+				continue
+			}
+			periods = append(periods, p)
+		}
+		a.Periods = periods
+		if absent {
+			a.Periods = append(a.Periods, AbsencePeriod{today, today})
+		}
+	}, func(a *Absence, now time.Time) string {
+		if absent {
+			return "Ok, oggi non ci sei: niente reminder per oggi"
+		}
+		if a.Absent(now) {
+			return "Ok, ma oggi è uno dei tuoi giorni di lavoro da remoto"
+		}
+		return "Ok, oggi ci sei"
+	})
+}
+
+// Absent shows or sets the absences of the user: "assente dal <giorno> al <giorno>", "assente oggi",
+// "assente remoto <giorni>", "assente niente on|off", "assente off"
+func (t *TinaBot) Absent(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	arg := strings.TrimSpace(args[1])
+	fields := strings.Fields(arg)
+	usage := "Usa `assente dal <giorno> al <giorno>`, `assente oggi`, `assente remoto <giorni>`, `assente niente on|off` oppure `assente off`"
+
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+	now := time.Now().In(loc)
+
+	if len(fields) == 0 {
+		a := LoadAbsences(t.brain)[user.ID]
+		bot.Message(msg.Channel, a.Format(t.codes))
+		return
+	}
+
+	var modify func(a *Absence, today string)
+	switch strings.ToLower(fields[0]) {
+	case "oggi":
+		modify = func(a *Absence, today string) {
+			a.Periods = append(a.Periods, AbsencePeriod{today, today})
+		}
+	case "off":
+		modify = func(a *Absence, today string) {
+			a.Periods = nil
+		}
+	case "remoto":
+		mask, ok := parseWeekMask(strings.Join(fields[1:], " "))
+		if !ok {
+			bot.Message(msg.Channel, usage)
+			return
+		}
+		modify = func(a *Absence, today string) {
+			a.Remote = mask & 0x7f
+		}
+	case "niente":
+		if len(fields) != 2 || (strings.ToLower(fields[1]) != "on" && strings.ToLower(fields[1]) != "off") {
+			bot.Message(msg.Channel, usage)
+			return
+		}
+		on := strings.ToLower(fields[1]) == "on"
+		modify = func(a *Absence, today string) {
+			a.MarkNone = on
+		}
+	default:
+		p, err := parseAbsencePeriod(arg, now)
+		if err != nil {
+			bot.Message(msg.Channel, err.Error()+"\n"+usage)
+			return
+		}
+		modify = func(a *Absence, today string) {
+			a.Periods = append(a.Periods, p)
+		}
+	}
+
+	t.setAbsence(msg, user, modify, func(a *Absence, now time.Time) string {
+		return "Ok\n" + a.Format(t.codes)
+	})
+}
+
+func (t *TinaBot) setAbsence(msg *slackbot.BotMsg, user *slack.User, modify func(a *Absence, today string), reply func(a *Absence, now time.Time) string) {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+	now := time.Now().In(loc)
+
+	a, err := updateAbsence(t.brain, user.ID, now, func(a *Absence) {
+		modify(a, now.Format("2006-01-02"))
+	})
+	if err != nil {
+		log.Println(err)
+		t.bot.Message(msg.Channel, "Non sono riuscito a salvare l'assenza, riprova: "+err.Error())
+		return
+	}
+	t.bot.Message(msg.Channel, reply(&a, now))
+}
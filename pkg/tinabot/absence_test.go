@@ -0,0 +1,77 @@
+package tinabot
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+)
+
+func TestParseAbsencePeriod(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	p, err := parseAbsencePeriod("dal 20 al 23", now)
+	assertEqual(t, err, nil, "")
+	assertEqual(t, p, AbsencePeriod{"2026-10-20", "2026-10-23"}, "")
+
+	// already over, next month
+	p, _ = parseAbsencePeriod("dal 3 al 7", now)
+	assertEqual(t, p, AbsencePeriod{"2026-11-03", "2026-11-07"}, "")
+
+	p, _ = parseAbsencePeriod("dal 28 al 2", now)
+	assertEqual(t, p, AbsencePeriod{"2026-10-28", "2026-11-02"}, "")
+
+	p, _ = parseAbsencePeriod("dal 20/12 al 6/1", now)
+	assertEqual(t, p, AbsencePeriod{"2026-12-20", "2027-01-06"}, "")
+
+	_, err = parseAbsencePeriod("dal 31/11 al 2/12", now)
+	assertNotEqual(t, err, nil, "")
+	_, err = parseAbsencePeriod("domani", now)
+	assertNotEqual(t, err, nil, "")
+}
+
+func TestAbsence(t *testing.T) {
+	b := brain.NewBrainMock()
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	a, err := updateAbsence(b, "U1", now, func(a *Absence) {
+		a.Periods = append(a.Periods, AbsencePeriod{"2026-10-21", "2026-10-22"}, AbsencePeriod{"2026-10-01", "2026-10-05"})
+		a.Remote = 1 << uint(time.Friday)
+	})
+	assertEqual(t, err, nil, "")
+	// the periods already over are dropped
+	assertEqual(t, len(a.Periods), 1, "")
+
+	assertEqual(t, IsAbsent(b, "U1", now), false, "")
+	assertEqual(t, IsAbsent(b, "U1", now.AddDate(0, 0, 2)), true, "")
+	assertEqual(t, IsAbsent(b, "U1", now.AddDate(0, 0, 4)), true, "")
+	assertEqual(t, IsAbsent(b, "U2", now), false, "")
+	assertEqual(t, len(AbsentToMark(b, now.AddDate(0, 0, 2))), 0, "")
+
+	updateAbsence(b, "U1", now, func(a *Absence) { a.MarkNone = true })
+	assertEqual(t, strings.Join(AbsentToMark(b, now.AddDate(0, 0, 2)), ","), "U1", "")
+
+	// nothing left, the user is removed
+	updateAbsence(b, "U1", now, func(a *Absence) { *a = Absence{} })
+	assertEqual(t, len(LoadAbsences(b)), 0, "")
+}
+
+func TestNotOrdered(t *testing.T) {
+	b := brain.NewBrainMock()
+	b.Set("remind", map[string]int{"U1": 0xff, "U2": 0xff, "U3": 0xff, "U4": 1 << uint(time.Tuesday)})
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	updateAbsence(b, "U3", now, func(a *Absence) {
+		a.Periods = []AbsencePeriod{{"2026-10-19", "2026-10-19"}}
+	})
+
+	order := Order{Users: map[User]UserChoiceArray{{Name: "u1", ID: "U1"}: nil}}
+	assertEqual(t, strings.Join(NotOrdered(b, &order, now), ","), "U2", "")
+
+	// absent users are not reminded
+	b.Set("remind_times", map[string]string{})
+	due, _ := DueReminders(b, now.Add(2*time.Hour))
+	sort.Strings(due)
+	assertEqual(t, strings.Join(due, ","), "U1,U2", "")
+}
@@ -0,0 +1,61 @@
+package tinabot
+
+import (
+	"time"
+)
+
+// archiveKey returns the brain key of the archive holding the orders of the month
+func archiveKey(t time.Time) string {
+	return "archive:" + t.Format("2006-01")
+}
+
+// archiveOrder saves the order in the monthly archive, replacing the one of the same day.
+// Empty orders are removed from the archive. If the saved order has been changed again in the
+// meantime the latest one is archived instead, so that an older copy can't overwrite a newer one.
+func archiveOrder(brain DataStore, order *Order) error {
+	date := order.Timestamp.Format("2006-01-02")
+	var archive map[string]Order
+	return brain.Update(archiveKey(order.Timestamp), &archive, func() error {
+		if archive == nil {
+			archive = make(map[string]Order)
+		}
+
+		latest := *order
+		var current Order
+		if brain.Get("order", &current) == nil && current.Timestamp.Format("2006-01-02") == date {
+			latest = current
+		}
+
+		if len(latest.Users) == 0 {
+			delete(archive, date)
+		} else {
+			archive[date] = latest
+		}
+		return nil
+	})
+}
+
+// loadArchive returns the archived orders between the from and to days included, sorted by date
+func loadArchive(brain DataStore, from, to time.Time) []Order {
+	first := from.Format("2006-01-02")
+	last := to.Format("2006-01-02")
+
+	var orders []Order
+	month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+	for !month.After(to) {
+		archive := make(map[string]Order)
+		brain.Get(archiveKey(month), &archive)
+
+		for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+			date := day.Format("2006-01-02")
+			if date < first || date > last {
+				continue
+			}
+			if o, ok := archive[date]; ok {
+				orders = append(orders, o)
+			}
+		}
+		month = month.AddDate(0, 1, 0)
+	}
+	return orders
+}
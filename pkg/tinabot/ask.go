@@ -0,0 +1,87 @@
+package tinabot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+const dishConversation = "dish"
+
+// maxDishOptions is the maximum number of matching dishes for which the user is asked to choose,
+// with more matches the request is just too vague
+const maxDishOptions = 9
+
+// replaceDish replaces the ambiguous dish string in the order request with the exact name of
+// the chosen dish. The occurrence to replace is the one followed by other after occurrences,
+// so that the request can have a prefix (e.g. the edit commands) and other dishes containing
+// the same string.
+func replaceDish(request, dish, content string, after int) string {
+	content = strings.Replace(content, "\\", "\\\\", -1)
+	content = strings.Replace(content, "+", "\\+", -1)
+
+	lower, ldish := strings.ToLower(request), strings.ToLower(dish)
+	var occ []int
+	for start := 0; ldish != ""; {
+		i := strings.Index(lower[start:], ldish)
+		if i < 0 {
+			break
+		}
+		occ = append(occ, start+i)
+		start += i + len(ldish)
+	}
+
+	n := len(occ) - 1 - after
+	if n < 0 || after < 0 {
+		return request
+	}
+	i := occ[n]
+	return request[:i] + content + request[i+len(dish):]
+}
+
+// askDish asks the user which of the dishes matching the ambiguous request she meant
+func (t *TinaBot) askDish(channel string, user *slack.User, dest, request string, amb *ambiguousDishError, reply string) {
+	if len(amb.Found) > maxDishOptions {
+		t.bot.Message(channel, reply+amb.Error())
+		return
+	}
+
+	var options []string
+	for _, d := range amb.Found {
+		options = append(options, d.Content)
+	}
+
+	t.bot.Ask(channel, user.ID, reply+fmt.Sprintf("Cercando per '%s' ho trovato più piatti, quale intendevi? Rispondi con il numero, oppure `0` per lasciar perdere:", amb.Dish),
+		slackbot.Conversation{
+			Action:  dishConversation,
+			Options: options,
+			Data: map[string]string{
+				"dest":    dest,
+				"request": request,
+				"dish":    amb.Dish,
+				"after":   strconv.Itoa(amb.After),
+			},
+		})
+}
+
+// answerDish completes the order once the user has chosen the dish
+func (t *TinaBot) answerDish(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, conv *slackbot.Conversation, text string) bool {
+	if strings.TrimSpace(text) == "0" {
+		bot.Message(msg.Channel, "Ok, ordine non aggiunto")
+		return true
+	}
+
+	i, ok := conv.Choice(text)
+	if !ok {
+		return false
+	}
+
+	after, _ := strconv.Atoi(conv.Data["after"])
+	request := replaceDish(conv.Data["request"], conv.Data["dish"], conv.Options[i], after)
+	t.For(bot, msg, user, "", conv.Data["dest"], request)
+	return true
+}
@@ -0,0 +1,283 @@
+package tinabot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// Budget is the daily lunch allowance covered by the company; what exceeds it is paid by the user.
+// The allowance of a user is her own override if set, otherwise the one of her role, otherwise the default.
+type Budget struct {
+	Default   decimal.Decimal
+	Roles     map[string]decimal.Decimal `json:",omitempty"` // allowance by role name
+	UserRoles map[string]string          `json:",omitempty"` // role by user ID
+	Users     map[string]decimal.Decimal `json:",omitempty"` // allowance override by user ID
+}
+
+func loadBudget(brain DataStore) *Budget {
+	var b Budget
+	brain.Get("budget", &b)
+	return &b
+}
+
+// Allowance returns the daily allowance of the user, false if there is no budget for her
+func (b *Budget) Allowance(user User) (decimal.Decimal, bool) {
+	if user.ID == "" {
+		return decimal.Zero, false
+	}
+	if a, ok := b.Users[user.ID]; ok {
+		return a, true
+	}
+	if r, ok := b.UserRoles[user.ID]; ok {
+		if a, ok := b.Roles[r]; ok {
+			return a, true
+		}
+	}
+	if b.Default.IsPositive() {
+		return b.Default, true
+	}
+	return decimal.Zero, false
+}
+
+// split returns the covered and the out-of-pocket parts of the price
+func (b *Budget) split(user User, price decimal.Decimal) (decimal.Decimal, decimal.Decimal, bool) {
+	a, ok := b.Allowance(user)
+	if !ok {
+		return price, decimal.Zero, false
+	}
+	if price.GreaterThan(a) {
+		return a, price.Sub(a), true
+	}
+	return price, decimal.Zero, true
+}
+
+// BillWithBudget returns the bill followed by the covered and out-of-pocket amounts of each user,
+// computed on what she pays for her guests too
+func (order *Order) BillWithBudget(b *Budget) string {
+	out := order.Bill()
+
+	costs := order.Costs()
+	payers := make(map[string]User)
+	for u := range order.Users {
+		if !isGuest(u) {
+			payers[u.Name] = u
+		}
+	}
+	for _, g := range order.Guests {
+		if g.Project == "" {
+			payers[g.Host.Name] = g.Host
+		}
+	}
+
+	var users []User
+	for name, u := range payers {
+		if _, ok := costs[name]; !ok {
+			continue
+		}
+		if _, ok := b.Allowance(u); ok {
+			users = append(users, u)
+		}
+	}
+	if len(users) == 0 {
+		return out
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+
+	var r []string
+	totalExcess := decimal.Zero
+	for _, u := range users {
+		covered, excess, _ := b.split(u, costs[u.Name])
+		l := fmt.Sprintf("%s: €%s coperti", u.Name, covered.StringFixed(2))
+		if excess.IsPositive() {
+			l += fmt.Sprintf(", *€%s a carico*", excess.StringFixed(2))
+			totalExcess = totalExcess.Add(excess)
+		}
+		r = append(r, l)
+	}
+	r = append(r, fmt.Sprintf("*Totale a carico dei dipendenti: €%s*", totalExcess.StringFixed(2)))
+	return out + "\n*Budget aziendale:*\n" + strings.Join(r, "\n")
+}
+
+// budgetWarning returns a warning if the choices of the user exceed her allowance
+func budgetWarning(brain DataStore, user User, choice []UserChoice) string {
+	b := loadBudget(brain)
+	price := UserChoiceArray(choice).Price()
+	_, excess, ok := b.split(user, price)
+	if !ok || !excess.IsPositive() {
+		return ""
+	}
+	a, _ := b.Allowance(user)
+	return fmt.Sprintf(":warning: Attenzione: l'ordine di %s costa €%s e supera il budget di €%s, €%s saranno a suo carico\n",
+		user.Name, price.StringFixed(2), a.StringFixed(2), excess.StringFixed(2))
+}
+
+// parseAllowance parses an allowance amount, "off" removes it
+func parseAllowance(s string) (decimal.Decimal, bool, error) {
+	if strings.ToLower(s) == "off" {
+		return decimal.Zero, false, nil
+	}
+	a, err := parseAmount(s)
+	return a, true, err
+}
+
+func (b *Budget) String() string {
+	var r []string
+	if b.Default.IsPositive() {
+		r = append(r, fmt.Sprintf("Budget giornaliero: €%s", b.Default.StringFixed(2)))
+	} else {
+		r = append(r, "Nessun budget giornaliero predefinito")
+	}
+
+	var roles []string
+	for role := range b.Roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		r = append(r, fmt.Sprintf("Ruolo %s: €%s", role, b.Roles[role].StringFixed(2)))
+	}
+
+	var ids []string
+	for id := range b.UserRoles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		r = append(r, fmt.Sprintf("<@%s>: ruolo %s", id, b.UserRoles[id]))
+	}
+
+	ids = nil
+	for id := range b.Users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		r = append(r, fmt.Sprintf("<@%s>: €%s", id, b.Users[id].StringFixed(2)))
+	}
+	return strings.Join(r, "\n")
+}
+
+// BudgetCmd shows or, for admins, configures the lunch budget:
+// "budget <importo>", "budget ruolo <ruolo> <importo>", "budget <utente> <importo>",
+// "budget <utente> ruolo <ruolo>"; "off" in place of the value removes the setting
+func (t *TinaBot) BudgetCmd(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	fields := strings.Fields(args[1])
+	if len(fields) == 0 {
+		b := loadBudget(t.brain)
+		reply := "Non hai nessun budget per il pranzo"
+		if a, ok := b.Allowance(User{user.Name, user.ID}); ok {
+			reply = fmt.Sprintf("Il tuo budget per il pranzo è di €%s", a.StringFixed(2))
+		}
+		bot.Message(msg.Channel, reply+"\n"+b.String())
+		return
+	}
+
+	if !isAdmin(user) {
+		bot.Message(msg.Channel, "Mi spiace, solo un amministratore può modificare il budget")
+		return
+	}
+
+	usage := "Usa `budget <importo>`, `budget ruolo <ruolo> <importo>`, `budget <utente> <importo>` oppure `budget <utente> ruolo <ruolo>`"
+	var reply string
+	var modify func(b *Budget) error
+
+	switch {
+	case len(fields) == 1:
+		a, _, err := parseAllowance(fields[0])
+		if err != nil {
+			bot.Message(msg.Channel, err.Error()+"\n"+usage)
+			return
+		}
+		modify = func(b *Budget) error {
+			b.Default = a
+			return nil
+		}
+		reply = "Ok, budget giornaliero impostato"
+
+	case len(fields) == 3 && strings.ToLower(fields[0]) == "ruolo":
+		role := strings.ToLower(fields[1])
+		a, set, err := parseAllowance(fields[2])
+		if err != nil {
+			bot.Message(msg.Channel, err.Error()+"\n"+usage)
+			return
+		}
+		modify = func(b *Budget) error {
+			if b.Roles == nil {
+				b.Roles = make(map[string]decimal.Decimal)
+			}
+			if set {
+				b.Roles[role] = a
+			} else {
+				delete(b.Roles, role)
+			}
+			return nil
+		}
+		reply = fmt.Sprintf("Ok, budget del ruolo %s aggiornato", role)
+
+	case len(fields) == 2 || (len(fields) == 3 && strings.ToLower(fields[1]) == "ruolo"):
+		u := getUserInfo(bot.Client, fields[0])
+		if u == nil {
+			bot.Message(msg.Channel, fmt.Sprintf("Utente '%s' non trovato", fields[0]))
+			return
+		}
+
+		if len(fields) == 3 {
+			role := strings.ToLower(fields[2])
+			modify = func(b *Budget) error {
+				if b.UserRoles == nil {
+					b.UserRoles = make(map[string]string)
+				}
+				if role == "off" {
+					delete(b.UserRoles, u.ID)
+				} else {
+					b.UserRoles[u.ID] = role
+				}
+				return nil
+			}
+			reply = fmt.Sprintf("Ok, ruolo di %s aggiornato", u.Name)
+			break
+		}
+
+		a, set, err := parseAllowance(fields[1])
+		if err != nil {
+			bot.Message(msg.Channel, err.Error()+"\n"+usage)
+			return
+		}
+		modify = func(b *Budget) error {
+			if b.Users == nil {
+				b.Users = make(map[string]decimal.Decimal)
+			}
+			if set {
+				b.Users[u.ID] = a
+			} else {
+				delete(b.Users, u.ID)
+			}
+			return nil
+		}
+		reply = fmt.Sprintf("Ok, budget di %s aggiornato", u.Name)
+
+	default:
+		bot.Message(msg.Channel, usage)
+		return
+	}
+
+	var b Budget
+	err := t.brain.Update("budget", &b, func() error {
+		return modify(&b)
+	})
+	if err != nil {
+		log.Println(err)
+		bot.Message(msg.Channel, "Non sono riuscito a salvare il budget, riprova: "+err.Error())
+		return
+	}
+	bot.Message(msg.Channel, reply+"\n"+b.String())
+}
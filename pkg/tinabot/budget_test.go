@@ -0,0 +1,56 @@
+package tinabot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestBudget(t *testing.T) {
+	b := Budget{
+		Default:   decimal.New(7, 0),
+		Roles:     map[string]decimal.Decimal{"manager": decimal.New(10, 0)},
+		UserRoles: map[string]string{"2": "manager"},
+		Users:     map[string]decimal.Decimal{"3": decimal.New(5, 0)},
+	}
+
+	a, ok := b.Allowance(User{"a", "1"})
+	assertEqual(t, ok, true, "")
+	assertEqual(t, a.String(), "7", "")
+	a, _ = b.Allowance(User{"b", "2"})
+	assertEqual(t, a.String(), "10", "")
+	a, _ = b.Allowance(User{"c", "3"})
+	assertEqual(t, a.String(), "5", "")
+	_, ok = b.Allowance(User{"guest_x", ""})
+	assertEqual(t, ok, false, "")
+	_, ok = (&Budget{}).Allowance(User{"a", "1"})
+	assertEqual(t, ok, false, "")
+
+	var primo UserChoice
+	primo.Add(tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo, Price: decimal.New(6, 0)})
+	order := NewOrder()
+	order.Set(User{"a", "1"}, []UserChoice{primo, primo})
+	order.Set(User{"c", "3"}, []UserChoice{primo})
+	bill := order.BillWithBudget(&b)
+	assertEqual(t, strings.HasSuffix(bill, "*Budget aziendale:*\na: €7.00 coperti, *€5.00 a carico*\nc: €5.00 coperti, *€1.00 a carico*\n*Totale a carico dei dipendenti: €6.00*"), true, bill)
+	assertEqual(t, order.BillWithBudget(&Budget{}), order.Bill(), "")
+
+	// guests are paid by the host, or by the project
+	order.Set(User{"guest_x", ""}, []UserChoice{primo})
+	order.SetGuest("guest_x", Guest{Host: User{"c", "3"}})
+	order.Set(User{"guest_y", ""}, []UserChoice{primo})
+	order.SetGuest("guest_y", Guest{Host: User{"c", "3"}, Project: "acme"})
+	bill = order.BillWithBudget(&b)
+	assertEqual(t, strings.Contains(bill, "*Da pagare, con gli ospiti:*\na: €12.00\nc: €12.00\nprogetto acme: €6.00\n"), true, bill)
+	assertEqual(t, strings.HasSuffix(bill, "\nc: €5.00 coperti, *€7.00 a carico*\n*Totale a carico dei dipendenti: €12.00*"), true, bill)
+
+	br := brain.NewBrainMock()
+	br.Set("budget", b)
+	assertEqual(t, budgetWarning(br, User{"b", "2"}, []UserChoice{primo}), "", "")
+	w := budgetWarning(br, User{"a", "1"}, []UserChoice{primo, primo})
+	assertEqual(t, strings.Contains(w, "€5.00 saranno a suo carico"), true, w)
+}
@@ -0,0 +1,112 @@
+package tinabot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/calendar"
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// closureRe matches a closure date or period, allowing spaces around the dash, followed by the note
+var closureRe = regexp.MustCompile(`^(\d{1,2}/\d{1,2}(?:\s*-\s*\d{1,2}/\d{1,2})?)(?:\s+(.*))?$`)
+
+// formatClosures returns the office closures followed by the public holidays of the next two months
+func formatClosures(closures []calendar.Closure, now time.Time) string {
+	var r []string
+	if len(closures) == 0 {
+		r = append(r, "Nessuna chiusura dell'ufficio in programma")
+	} else {
+		r = append(r, "*Chiusure dell'ufficio:*")
+		for i, c := range closures {
+			r = append(r, fmt.Sprintf("%d - %s", i, c.String()))
+		}
+	}
+
+	if h := calendar.NextHolidays(now, 60); len(h) > 0 {
+		r = append(r, "*Prossimi festivi:*")
+		r = append(r, h...)
+	}
+	return strings.Join(r, "\n")
+}
+
+// Closures shows or, for admins, sets the office closures: "chiusura gg/mm-gg/mm [nota]" adds one,
+// "chiusura rm <n>" removes it
+func (t *TinaBot) Closures(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+	now := time.Now().In(loc)
+
+	fields := strings.Fields(args[1])
+	if len(fields) == 0 {
+		bot.Message(msg.Channel, formatClosures(calendar.LoadClosures(t.brain), now))
+		return
+	}
+
+	if !isAdmin(user) {
+		bot.Message(msg.Channel, "Mi spiace, solo un amministratore può modificare le chiusure dell'ufficio")
+		return
+	}
+
+	var modify func(c *[]calendar.Closure) error
+	var reply string
+	errNoClosure := errors.New("no closure")
+
+	if strings.ToLower(fields[0]) == "rm" {
+		if len(fields) != 2 {
+			bot.Message(msg.Channel, "Usa `chiusura rm <n>`")
+			return
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			bot.Message(msg.Channel, "Errore di parsing indice: "+err.Error())
+			return
+		}
+		modify = func(c *[]calendar.Closure) error {
+			if n < 0 || n >= len(*c) {
+				return errNoClosure
+			}
+			reply = "Ok, rimossa la chiusura " + (*c)[n].String()
+			*c = append((*c)[:n], (*c)[n+1:]...)
+			return nil
+		}
+	} else {
+		m := closureRe.FindStringSubmatch(strings.TrimSpace(args[1]))
+		if m == nil {
+			bot.Message(msg.Channel, "Usa `chiusura gg/mm-gg/mm [nota]`")
+			return
+		}
+		closure, err := calendar.ParseClosure(m[1], now)
+		if err != nil {
+			bot.Message(msg.Channel, err.Error()+"\nUsa `chiusura gg/mm-gg/mm [nota]`")
+			return
+		}
+		closure.Note = strings.TrimSpace(m[2])
+		modify = func(c *[]calendar.Closure) error {
+			*c = append(*c, closure)
+			return nil
+		}
+		reply = "Ok, aggiunta la chiusura " + closure.String()
+	}
+
+	err = calendar.UpdateClosures(t.brain, now, modify)
+	if err == errNoClosure {
+		bot.Message(msg.Channel, "Indice inesistente!")
+		return
+	} else if err != nil {
+		log.Println(err)
+		bot.Message(msg.Channel, "Non sono riuscito a salvare le chiusure, riprova: "+err.Error())
+		return
+	}
+	bot.Message(msg.Channel, reply+"\n"+formatClosures(calendar.LoadClosures(t.brain), now))
+}
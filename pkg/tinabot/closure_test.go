@@ -0,0 +1,23 @@
+package tinabot
+
+import (
+	"testing"
+)
+
+func TestClosureRe(t *testing.T) {
+	tests := []struct {
+		in, period, note string
+	}{
+		{"10/08-25/08", "10/08-25/08", ""},
+		{"10/08 - 25/08 ferie estive", "10/08 - 25/08", "ferie estive"},
+		{"24/12 vigilia", "24/12", "vigilia"},
+	}
+	for _, tt := range tests {
+		m := closureRe.FindStringSubmatch(tt.in)
+		assertEqual(t, m != nil, true, tt.in)
+		assertEqual(t, m[1], tt.period, "")
+		assertEqual(t, m[2], tt.note, "")
+	}
+
+	assertEqual(t, closureRe.MatchString("ferie 10/08"), false, "")
+}
@@ -1,81 +1,222 @@
 package tinabot
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nlopes/slack"
 
+	"github.com/develersrl/lunches/pkg/scheduler"
 	"github.com/develersrl/lunches/pkg/slackbot"
-	"github.com/robfig/cron"
-
-	"github.com/go-redis/redis"
 )
 
-func (t *TinaBot) Cron(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+// taskExists checks that a task can be scheduled, it's a variable to be replaced in the tests
+var taskExists = scheduler.TaskExists
+
+// runTask runs a scheduled task, it's a variable to be replaced in the tests
+var runTask = scheduler.RunTask
+
+var errNoEntry = errors.New("cron inesistente")
 
-	var crontab []string
-	if args[1] != "" {
-		crontab = strings.SplitN(strings.TrimSpace(args[1]), " ", 2)
-	} else {
-		crontab = nil
+// parseCronEntry parses and validates a "<cron spec>;<task> [<args>]" string
+func parseCronEntry(s string) (scheduler.Entry, error) {
+	e, err := scheduler.ParseEntry(s)
+	if err != nil {
+		return e, fmt.Errorf("Errore di formato cron: %s", err.Error())
 	}
+	if !taskExists(e.Task) {
+		return e, fmt.Errorf("Il task '%s' non esiste", e.Task)
+	}
+	return e, nil
+}
 
-	if crontab == nil {
-		var sched []string
-		err := t.brain.Get("cron", &sched)
-		if err == redis.Nil || len(sched) == 0 {
+func formatEntries(entries scheduler.Entries) string {
+	var r []string
+	for _, e := range entries {
+		r = append(r, e.String())
+	}
+	return "```" + strings.Join(r, "\n") + "```"
+}
+
+// formatNextRuns returns the next run of each enabled entry, in chronological order
+func formatNextRuns(entries scheduler.Entries, now time.Time) string {
+	type run struct {
+		at time.Time
+		e  scheduler.Entry
+	}
+	var runs []run
+	for _, e := range entries {
+		if err := e.Parse(); err != nil || !e.Enabled {
+			continue
+		}
+		runs = append(runs, run{e.Next(now), e})
+	}
+	if len(runs) == 0 {
+		return "Non c'è nessun cron attivo"
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].at.Before(runs[j].at)
+	})
+
+	var r []string
+	for _, x := range runs {
+		r = append(r, fmt.Sprintf("%s %s - %d - %s", weekNames[x.at.Weekday()], x.at.Format("02/01 15:04"), x.e.ID, x.e.Command()))
+	}
+	return "```" + strings.Join(r, "\n") + "```"
+}
+
+// Cron manages the scheduled tasks: "cron" lists them, "cron add <spec>;<task> [<args>]" adds one,
+// "cron rm|disable|enable|run <id>", "cron edit <id> <spec>;<task> [<args>]" and "cron next".
+// Only the admins can change or run the entries.
+func (t *TinaBot) Cron(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	fields := strings.Fields(args[1])
+	if len(fields) == 0 {
+		entries := scheduler.LoadEntries(t.brain)
+		if len(entries) == 0 {
 			bot.Message(msg.Channel, "Non c'è nessun cron impostato")
 		} else {
-			reply := "```"
-			for i, s := range sched {
-				reply += fmt.Sprintf("%d - %s\n", i, s)
-			}
-			bot.Message(msg.Channel, reply+"```")
+			bot.Message(msg.Channel, formatEntries(entries))
 		}
-	} else {
-		if len(crontab) < 2 {
-			bot.Message(msg.Channel, "Argomenti insufficienti!")
+		return
+	}
+
+	cmd := strings.ToLower(fields[0])
+	rest := strings.TrimSpace(strings.TrimSpace(args[1])[len(fields[0]):])
+
+	if cmd == "next" {
+		loc, err := time.LoadLocation("Europe/Rome")
+		if err != nil {
+			log.Println("LoadLocation error: ", err)
 			return
 		}
+		bot.Message(msg.Channel, "Prossime esecuzioni:\n"+formatNextRuns(scheduler.LoadEntries(t.brain), time.Now().In(loc)))
+		return
+	}
 
-		switch strings.ToLower(crontab[0]) {
-		case "add":
-			cmd := strings.Split(crontab[1], ";")
-			if len(cmd) < 2 {
-				bot.Message(msg.Channel, "Argomenti insufficienti!")
-				return
-			}
-			_, err := cron.ParseStandard(cmd[0])
-			if err != nil {
-				bot.Message(msg.Channel, "Errore di formato cron: "+err.Error())
-				return
-			}
-			var sched []string
-			t.brain.Get("cron", &sched)
-			sched = append(sched, crontab[1])
-			t.brain.Set("cron", &sched)
-			bot.Message(msg.Channel, fmt.Sprintf("Ok, cron aggiunto:```%d - %s```", len(sched)-1, crontab[1]))
-		case "rm":
-			var sched []string
-			err := t.brain.Get("cron", &sched)
-			if err != nil || len(sched) == 0 {
-				bot.Message(msg.Channel, "Nessun cron impostato!")
-				return
+	if !isAdmin(user) {
+		bot.Message(msg.Channel, "Mi spiace, solo un amministratore può modificare o eseguire i cron")
+		return
+	}
+
+	if cmd == "add" {
+		e, err := parseCronEntry(rest)
+		if err != nil {
+			bot.Message(msg.Channel, err.Error())
+			return
+		}
+		e.CreatedBy = user.Name
+		err = scheduler.UpdateEntries(t.brain, func(es *scheduler.Entries) error {
+			e.ID = es.NextID()
+			*es = append(*es, e)
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			bot.Message(msg.Channel, "Non sono riuscito a salvare il cron, riprova: "+err.Error())
+			return
+		}
+		bot.Message(msg.Channel, fmt.Sprintf("Ok, cron aggiunto:```%s```", e.String()))
+		return
+	}
+
+	if len(fields) < 2 {
+		bot.Message(msg.Channel, "Argomenti insufficienti!")
+		return
+	}
+	id, err := strconv.Atoi(fields[1])
+	if err != nil {
+		bot.Message(msg.Channel, "Errore di parsing indice: "+err.Error())
+		return
+	}
+
+	var edited scheduler.Entry
+	if cmd == "edit" {
+		edited, err = parseCronEntry(strings.TrimSpace(rest[len(fields[1]):]))
+		if err != nil {
+			bot.Message(msg.Channel, err.Error())
+			return
+		}
+	}
+
+	if cmd == "run" {
+		entries := scheduler.LoadEntries(t.brain)
+		i := entries.Find(id)
+		if i < 0 {
+			bot.Message(msg.Channel, "Indice inesistente!")
+			return
+		}
+		e := entries[i]
+		bot.Message(msg.Channel, fmt.Sprintf("Ok, eseguo il cron ```%s```", e.Command()))
+
+		// This can be slow so spawn a goroutine to give Slack a fast reply and avoid retrys
+		go func() {
+			err := runTask(e.Task, e.Args)
+			if rerr := scheduler.Record(t.brain, e.ID, time.Now(), err); rerr != nil {
+				log.Println(rerr)
 			}
-			n, err := strconv.Atoi(crontab[1])
 			if err != nil {
-				bot.Message(msg.Channel, "Errore di parsing indice: "+err.Error())
+				bot.Message(msg.Channel, fmt.Sprintf("Errore nell'esecuzione del cron %d: %s", e.ID, err.Error()))
 				return
 			}
-			if n > len(sched)-1 || n < 0 {
-				bot.Message(msg.Channel, "Indice inesistente!")
-				return
-			}
-			bot.Message(msg.Channel, fmt.Sprintf("Ok, rimosso cron ```%d - %s```", n, sched[n]))
-			sched = append(sched[:n], sched[n+1:]...)
-			t.brain.Set("cron", sched)
+			bot.Message(msg.Channel, fmt.Sprintf("Cron %d eseguito", e.ID))
+		}()
+		return
+	}
+
+	var modify func(es *scheduler.Entries, i int)
+	var reply string
+	switch cmd {
+	case "rm":
+		modify = func(es *scheduler.Entries, i int) {
+			*es = append((*es)[:i], (*es)[i+1:]...)
+		}
+		reply = "Ok, rimosso cron"
+	case "disable", "enable":
+		enabled := cmd == "enable"
+		modify = func(es *scheduler.Entries, i int) {
+			(*es)[i].Enabled = enabled
+		}
+		reply = "Ok, attivato cron"
+		if !enabled {
+			reply = "Ok, disattivato cron"
+		}
+	case "edit":
+		modify = func(es *scheduler.Entries, i int) {
+			(*es)[i].Spec = edited.Spec
+			(*es)[i].Task = edited.Task
+			(*es)[i].Args = edited.Args
+		}
+		reply = "Ok, modificato cron"
+	default:
+		bot.Message(msg.Channel, fmt.Sprintf("Comando '%s' sconosciuto, usa `cron add|rm|edit|enable|disable|run|next`", fields[0]))
+		return
+	}
+
+	var entry scheduler.Entry
+	err = scheduler.UpdateEntries(t.brain, func(es *scheduler.Entries) error {
+		i := es.Find(id)
+		if i < 0 {
+			return errNoEntry
+		}
+		entry = (*es)[i]
+		modify(es, i)
+		if cmd != "rm" {
+			entry = (*es)[i]
 		}
+		return nil
+	})
+	if err == errNoEntry {
+		bot.Message(msg.Channel, "Indice inesistente!")
+		return
+	} else if err != nil {
+		log.Println(err)
+		bot.Message(msg.Channel, "Non sono riuscito a salvare il cron, riprova: "+err.Error())
+		return
 	}
+	bot.Message(msg.Channel, fmt.Sprintf("%s ```%s```", reply, entry.String()))
 }
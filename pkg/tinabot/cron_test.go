@@ -0,0 +1,42 @@
+package tinabot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/scheduler"
+)
+
+func TestParseCronEntry(t *testing.T) {
+	defer func(f func(string) bool) { taskExists = f }(taskExists)
+	taskExists = func(task string) bool {
+		return task == "post"
+	}
+
+	e, err := parseCronEntry("50 11 * * 1-5;post #pranzo ciao")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, e.Command(), "50 11 * * 1-5;post #pranzo ciao", "")
+
+	_, err = parseCronEntry("50 11 * * 1-5;nonesiste")
+	assertEqual(t, err.Error(), "Il task 'nonesiste' non esiste", "")
+	_, err = parseCronEntry("50 11 * *;post")
+	assertNotEqual(t, err, nil, "")
+}
+
+func TestFormatNextRuns(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	entries := scheduler.Entries{
+		{ID: 1, Spec: "50 11 * * 1-5", Task: "reminder", Enabled: true},
+		{ID: 2, Spec: "0 14 * * 1-5", Task: "mark", Enabled: true},
+		{ID: 3, Spec: "0 13 * * *", Task: "post", Enabled: false},
+	}
+
+	out := formatNextRuns(entries, now)
+	lines := strings.Split(strings.Trim(out, "`"), "\n")
+	assertEqual(t, len(lines), 2, out)
+	assertEqual(t, lines[0], "lunedì 19/10 14:00 - 2 - 0 14 * * 1-5;mark", "")
+	assertEqual(t, lines[1], "martedì 20/10 11:50 - 1 - 50 11 * * 1-5;reminder", "")
+
+	assertEqual(t, formatNextRuns(entries[2:], now), "Non c'è nessun cron attivo", "")
+}
@@ -0,0 +1,243 @@
+package tinabot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+// allergenKeywords maps each allergen (or food category) tag to the keywords that, if found
+// in a dish name, indicate that the dish may contain it.
+// It can be extended by storing a map with the same format in the "allergens" brain key.
+var allergenKeywords = map[string][]string{
+	"carne": {"pollo", "manzo", "maiale", "vitello", "salsiccia", "ragù", "prosciutto", "speck",
+		"pancetta", "tacchino", "agnello", "bistecca", "polpett", "arrosto", "peposo", "bollito",
+		"guanciale", "coniglio", "hamburger", "bresaola", "salame", "mortadella", "cotoletta",
+		"carne", "lesso", "spezzatino", "tagliata", "wurstel"},
+	"pesce": {"pesce", "tonno", "salmone", "merluzzo", "baccalà", "scorfano", "orata", "branzino",
+		"acciug", "alici", "gamber", "cozze", "vongole", "calamar", "polpo", "seppi", "frutti di mare",
+		"pesce spada", "sgombro", "trota", "totani"},
+	"glutine": {"pasta", "fusilli", "penne", "spaghetti", "lasagn", "pane", "panino", "pizza",
+		"farro", "orzo", "cous cous", "couscous", "gnocchi", "raviol", "tortellin", "impanat",
+		"cotoletta", "crostat", "torta", "besciamella", "tagliatell", "pappardell", "rigatoni",
+		"maccheroni", "focaccia", "crostini", "crespell", "piadin", "sformato"},
+	"lattosio": {"formaggi", "parmigiano", "mozzarella", "besciamella", "panna", "burro", "ricotta",
+		"latte", "pecorino", "gorgonzola", "stracchino", "crema", "tiramisù", "mascarpone",
+		"scamorza", "caprese", "parmigiana", "yogurt", "budino", "gratin"},
+	"frutta secca": {"noci", "nocciol", "mandorl", "pistacch", "pinoli", "anacardi", "pesto",
+		"arachid"},
+	"uova": {"uov", "frittata", "carbonara", "maionese", "tiramisù", "sformato"},
+}
+
+// dietRestriction is a dietary restriction that can be set in the user profile
+type dietRestriction struct {
+	Name    string
+	Aliases []string
+	Tags    []string
+}
+
+var dietRestrictions = []dietRestriction{
+	{"vegetariano", []string{"vegetarian"}, []string{"carne", "pesce"}},
+	{"vegano", []string{"vegan"}, []string{"carne", "pesce", "lattosio", "uova"}},
+	{"celiaco", []string{"celiac", "glutine"}, []string{"glutine"}},
+	{"no lattosio", []string{"lattosio", "latte"}, []string{"lattosio"}},
+	{"frutta secca", []string{"noci", "nocciole", "arachidi"}, []string{"frutta secca"}},
+	{"no pesce", []string{"pesce"}, []string{"pesce"}},
+	{"no carne", []string{"carne"}, []string{"carne"}},
+	{"no uova", []string{"uova", "uovo"}, []string{"uova"}},
+}
+
+func findRestriction(name string) *dietRestriction {
+	for i, r := range dietRestrictions {
+		if r.Name == name {
+			return &dietRestrictions[i]
+		}
+	}
+	return nil
+}
+
+// parseDiet parses a comma separated list of dietary restrictions, returning the
+// canonical restriction names and the items that were not recognized
+func parseDiet(s string) ([]string, []string) {
+	var diet, unknown []string
+	found := make(map[string]bool)
+
+	for _, item := range strings.Split(strings.ToLower(s), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var match *dietRestriction
+		for i, r := range dietRestrictions {
+			if strings.Contains(item, r.Name) {
+				match = &dietRestrictions[i]
+				break
+			}
+		}
+		if match == nil {
+			for i, r := range dietRestrictions {
+				for _, a := range r.Aliases {
+					if strings.Contains(item, a) {
+						match = &dietRestrictions[i]
+						break
+					}
+				}
+				if match != nil {
+					break
+				}
+			}
+		}
+
+		if match == nil {
+			unknown = append(unknown, item)
+		} else if !found[match.Name] {
+			found[match.Name] = true
+			diet = append(diet, match.Name)
+		}
+	}
+	return diet, unknown
+}
+
+// loadAllergens returns the allergen keyword table, extended with the one stored in the brain
+func loadAllergens(brain DataStore) map[string][]string {
+	table := make(map[string][]string)
+	for k, v := range allergenKeywords {
+		table[k] = v
+	}
+
+	var custom map[string][]string
+	if brain.Get("allergens", &custom) == nil {
+		for k, v := range custom {
+			table[k] = append(table[k], v...)
+		}
+	}
+	return table
+}
+
+// dishAllergens returns the tags whose keywords are found in the dish name
+func dishAllergens(table map[string][]string, dish tuttobene.MenuRow) []string {
+	content := strings.ToLower(dish.Content)
+
+	var tags []string
+	for tag, keywords := range table {
+		// dishes in the vegetarian section can't contain meat or fish
+		if dish.Type == tuttobene.Vegetariano && (tag == "carne" || tag == "pesce") {
+			continue
+		}
+		for _, k := range keywords {
+			if strings.Contains(content, strings.ToLower(k)) {
+				tags = append(tags, tag)
+				break
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// dietConflicts returns the tags of the dish that conflict with the diet profile
+func dietConflicts(table map[string][]string, diet []string, dish tuttobene.MenuRow) []string {
+	avoid := make(map[string]bool)
+	for _, d := range diet {
+		if r := findRestriction(d); r != nil {
+			for _, t := range r.Tags {
+				avoid[t] = true
+			}
+		}
+	}
+
+	var conflicts []string
+	for _, t := range dishAllergens(table, dish) {
+		if avoid[t] {
+			conflicts = append(conflicts, t)
+		}
+	}
+	return conflicts
+}
+
+func loadDiet(brain DataStore, userID string) []string {
+	var diets map[string][]string
+	brain.Get("diet", &diets)
+	return diets[userID]
+}
+
+// dietWarnings returns a warning for each dish of the choices conflicting with the user profile
+func dietWarnings(brain DataStore, user User, choice []UserChoice) string {
+	if user.ID == "" {
+		return ""
+	}
+
+	diet := loadDiet(brain, user.ID)
+	if len(diet) == 0 {
+		return ""
+	}
+
+	table := loadAllergens(brain)
+	out := ""
+	for _, c := range choice {
+		for _, d := range c.Dishes {
+			if conflicts := dietConflicts(table, diet, d); len(conflicts) > 0 {
+				out += fmt.Sprintf(":warning: Attenzione: '%s' potrebbe contenere %s, incompatibile con il profilo di %s (%s)\n",
+					d.Content, strings.Join(conflicts, ", "), user.Name, strings.Join(diet, ", "))
+			}
+		}
+	}
+	return out
+}
+
+// Diet shows or sets the dietary profile of the user
+func (t *TinaBot) Diet(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	arg := strings.TrimSpace(args[1])
+
+	diets := make(map[string][]string)
+	t.brain.Get("diet", &diets)
+
+	if arg == "" {
+		if diet, ok := diets[user.ID]; ok {
+			bot.Message(msg.Channel, "Il tuo profilo alimentare: "+strings.Join(diet, ", "))
+		} else {
+			bot.Message(msg.Channel, "Non hai impostato nessun profilo alimentare")
+		}
+		return
+	}
+
+	switch strings.ToLower(arg) {
+	case "off", "nessuna", "niente":
+		delete(diets, user.ID)
+		t.brain.Set("diet", diets)
+		bot.Message(msg.Channel, "Ok, profilo alimentare rimosso")
+		return
+	}
+
+	diet, unknown := parseDiet(arg)
+	if len(unknown) > 0 {
+		var valid []string
+		for _, r := range dietRestrictions {
+			valid = append(valid, r.Name)
+		}
+		bot.Message(msg.Channel, fmt.Sprintf("Scusami, non conosco: %s\nValori validi sono: %s", strings.Join(unknown, ", "), strings.Join(valid, ", ")))
+		return
+	}
+
+	diets[user.ID] = diet
+	t.brain.Set("diet", diets)
+	bot.Message(msg.Channel, "Ok, profilo alimentare impostato: "+strings.Join(diet, ", "))
+}
+
+// dietMenuMarker returns a function marking the menu rows compatible with the user profile
+func dietMenuMarker(brain DataStore, userID string) func(tuttobene.MenuRow) string {
+	diet := loadDiet(brain, userID)
+	table := loadAllergens(brain)
+	return func(r tuttobene.MenuRow) string {
+		if len(dietConflicts(table, diet, r)) > 0 {
+			return ":x: "
+		}
+		return ":white_check_mark: "
+	}
+}
@@ -0,0 +1,42 @@
+package tinabot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestParseDiet(t *testing.T) {
+	diet, unknown := parseDiet("Vegetariano, no lattosio, allergia alla frutta secca, celiaco, vegetariano")
+	assertEqual(t, strings.Join(diet, ","), "vegetariano,no lattosio,frutta secca,celiaco", "")
+	assertEqual(t, len(unknown), 0, "")
+
+	diet, unknown = parseDiet("vegano, fruttariano")
+	assertEqual(t, strings.Join(diet, ","), "vegano", "")
+	assertEqual(t, strings.Join(unknown, ","), "fruttariano", "")
+}
+
+func TestDietConflicts(t *testing.T) {
+	table := loadAllergens(brain.NewBrainMock())
+
+	lasagne := tuttobene.MenuRow{Content: "Lasagne al ragù", Type: tuttobene.Primo}
+	parmigiana := tuttobene.MenuRow{Content: "Parmigiana di melanzane", Type: tuttobene.Vegetariano}
+	patate := tuttobene.MenuRow{Content: "Patate al forno", Type: tuttobene.Contorno}
+
+	assertEqual(t, strings.Join(dishAllergens(table, lasagne), ","), "carne,glutine", "")
+	assertEqual(t, strings.Join(dietConflicts(table, []string{"vegetariano"}, lasagne), ","), "carne", "")
+	assertEqual(t, len(dietConflicts(table, []string{"vegetariano"}, parmigiana)), 0, "")
+	assertEqual(t, strings.Join(dietConflicts(table, []string{"vegano"}, parmigiana), ","), "lattosio", "")
+	assertEqual(t, len(dietConflicts(table, []string{"vegano", "celiaco"}, patate)), 0, "")
+
+	b := brain.NewBrainMock()
+	b.Set("diet", map[string][]string{"123": {"celiaco"}})
+	b.Set("allergens", map[string][]string{"glutine": {"melanzane"}})
+	var choice UserChoice
+	choice.Add(parmigiana)
+	w := dietWarnings(b, User{"test", "123"}, []UserChoice{choice})
+	assertEqual(t, strings.Contains(w, "glutine"), true, w)
+	assertEqual(t, dietWarnings(b, User{"test2", "456"}, []UserChoice{choice}), "", "")
+}
@@ -0,0 +1,239 @@
+package tinabot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+var editRe = regexp.MustCompile("^(?i)(aggiungi|togli|sostituisci)\\s+(.*)$")
+
+// dishRef references a single dish inside a UserChoiceArray.
+// If dish is negative the reference is to the whole choice.
+type dishRef struct {
+	choice int
+	dish   int
+}
+
+// findDish returns the references to the dishes matching the given string
+func (u UserChoiceArray) findDish(dish string) []dishRef {
+	dish = strings.TrimSpace(dish)
+
+	// exact matches on the whole choice or on a single dish win over fuzzy ones
+	for i, c := range u {
+		if strings.EqualFold(c.String(), dish) {
+			if len(c.Dishes) == 1 {
+				return []dishRef{{i, 0}}
+			}
+			return []dishRef{{i, -1}}
+		}
+		for j, d := range c.Dishes {
+			if strings.EqualFold(d.Content, dish) {
+				return []dishRef{{i, j}}
+			}
+		}
+	}
+
+	var refs []dishRef
+	for i, c := range u {
+		for j, d := range c.Dishes {
+			if fuzzyMatch(dish, d.Content) {
+				refs = append(refs, dishRef{i, j})
+			}
+		}
+	}
+	return refs
+}
+
+func (u UserChoiceArray) dishName(ref dishRef) string {
+	if ref.dish < 0 {
+		return u[ref.choice].String()
+	}
+	c := u[ref.choice]
+	return c.dishString(c.Dishes[ref.dish])
+}
+
+// rebuildChoice creates a new choice from the given dishes, checking the composition rules
+// and keeping the notes found in the given notes maps
+func rebuildChoice(dishes []tuttobene.MenuRow, notes ...map[string]string) (UserChoice, error) {
+	var c UserChoice
+	for _, d := range dishes {
+		if err := c.Add(d); err != nil {
+			return UserChoice{}, err
+		}
+		for _, n := range notes {
+			if note, ok := n[d.Content]; ok {
+				c.SetNote(d.Content, note)
+			}
+		}
+	}
+	return c, nil
+}
+
+// replace returns a copy of u where the referenced dish is replaced by with.
+// If the dish is part of a composed plate, with must be a single dish that can be
+// composed with the rest of the plate. If with is empty the dish is removed.
+func (u UserChoiceArray) replace(ref dishRef, with []UserChoice) (UserChoiceArray, error) {
+	var out UserChoiceArray
+	out = append(out, u[:ref.choice]...)
+
+	c := u[ref.choice]
+	if ref.dish < 0 || len(c.Dishes) == 1 {
+		out = append(out, with...)
+	} else {
+		var dishes []tuttobene.MenuRow
+		notes := []map[string]string{c.Notes}
+		dishes = append(dishes, c.Dishes[:ref.dish]...)
+		if len(with) > 0 {
+			if len(with) > 1 || len(with[0].Dishes) != 1 {
+				return nil, errors.New("in un piatto personalizzato è possibile sostituire un solo piatto alla volta")
+			}
+			dishes = append(dishes, with[0].Dishes[0])
+			// the notes of the new dish take precedence over the old ones
+			notes = append(notes, with[0].Notes)
+		}
+		dishes = append(dishes, c.Dishes[ref.dish+1:]...)
+
+		newChoice, err := rebuildChoice(dishes, notes...)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, newChoice)
+	}
+
+	out = append(out, u[ref.choice+1:]...)
+	return out, nil
+}
+
+// findOne finds a single dish in the choices, returning an error if there is not exactly one match
+func (u UserChoiceArray) findOne(dish string) (dishRef, error) {
+	refs := u.findDish(dish)
+	if len(refs) == 0 {
+		return dishRef{}, fmt.Errorf("Non ho trovato nulla nell'ordine che corrisponda a '%s'", dish)
+	} else if len(refs) > 1 {
+		var matches []string
+		for _, r := range refs {
+			matches = append(matches, u.dishName(r))
+		}
+		return dishRef{}, fmt.Errorf("Cercando per '%s' nell'ordine ho trovato i seguenti piatti:\n%s\n----\nprova ad essere più preciso!", dish, strings.Join(matches, "\n"))
+	}
+	return refs[0], nil
+}
+
+// editChoice applies the edit command (aggiungi, togli, sostituisci) to the current choices,
+// returning the new choices and a textual report of what has been done.
+func editChoice(menu tuttobene.Menu, current UserChoiceArray, cmd, arg string) (UserChoiceArray, string, error) {
+	switch strings.ToLower(cmd) {
+	case "aggiungi":
+		choice, reply, err := parseOrder(menu, arg)
+		if err != nil {
+			return nil, reply, err
+		}
+		var out UserChoiceArray
+		out = append(out, current...)
+		return append(out, choice...), reply, nil
+
+	case "togli":
+		ref, err := current.findOne(arg)
+		if err != nil {
+			return nil, "", err
+		}
+		reply := "Tolgo: " + current.dishName(ref) + "\n"
+		out, err := current.replace(ref, nil)
+		return out, reply, err
+
+	case "sostituisci":
+		// " con " may be part of the dish names, try every split point until one works
+		parts := strings.Split(arg, " con ")
+		if len(parts) < 2 {
+			return nil, "", errors.New("Usa `sostituisci <piatto> con <piatto>`")
+		}
+
+		var lastErr error
+		lastReply := ""
+		for i := 1; i < len(parts); i++ {
+			from := strings.Join(parts[:i], " con ")
+			to := strings.Join(parts[i:], " con ")
+
+			ref, err := current.findOne(from)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			choice, reply, err := parseOrder(menu, to)
+			if err != nil {
+				lastErr, lastReply = err, reply
+				continue
+			}
+
+			reply = "Sostituisco: " + current.dishName(ref) + "\n" + reply
+			out, err := current.replace(ref, choice)
+			return out, reply, err
+		}
+		return nil, lastReply, lastErr
+	}
+
+	return nil, "", fmt.Errorf("comando '%s' non valido", cmd)
+}
+
+// editOrder handles the incremental edit commands for the "per <utente>" command.
+// Ambiguous dish errors are returned without replying, so that the caller can ask the user.
+func (t *TinaBot) editOrder(bot *slackbot.Bot, channel, destCh string, user, destUser User, menu tuttobene.Menu, cmd, arg string) error {
+	var choice []UserChoice
+	var list []string
+	var reply string
+	var entry undoEntry
+	errNoOrder := fmt.Errorf("%s non ha ancora ordinato nulla", destUser.Name)
+
+	err := UpdateOrder(t.brain, func(order *Order) error {
+		current, ok := order.Users[destUser]
+		if !ok && strings.ToLower(cmd) != "aggiungi" {
+			return errNoOrder
+		}
+
+		var err error
+		choice, reply, err = editChoice(menu, current, cmd, arg)
+		if err != nil {
+			return err
+		}
+
+		entry = newUndoEntry(order, fmt.Sprintf("per %s %s %s", destUser.Name, cmd, arg), destUser)
+		list = nil
+		if len(choice) == 0 {
+			order.ClearUser(destUser)
+		} else {
+			list = setChoices(t.brain, t.pricing, order, destUser, user, choice)
+		}
+		return nil
+	})
+	if _, ok := err.(*ambiguousDishError); ok {
+		return err
+	} else if err == errNoOrder {
+		bot.Message(channel, err.Error())
+		return nil
+	} else if err != nil {
+		bot.Message(channel, reply+err.Error()+"\nOrdine non modificato!")
+		return nil
+	}
+
+	reply += dietWarnings(t.brain, destUser, choice)
+	reply += budgetWarning(t.brain, destUser, choice)
+	pushUndo(t.brain, user, entry)
+	log.Printf("Order of %s modified by %s\n", destUser.Name, user.Name)
+
+	summary := strings.Join(list, "\n")
+	if summary == "" {
+		summary = "nessun piatto"
+	}
+	bot.Message(channel, reply+fmt.Sprintf("Ok, ordine di %s modificato:\n%s", destUser.Name, summary))
+	if destCh != "" {
+		bot.Message(destCh, fmt.Sprintf("Ti volevo informare che <@%s> ha modificato il tuo ordine, ecco i piatti ordinati per conto tuo:\n%s", user.ID, summary))
+	}
+	return nil
+}
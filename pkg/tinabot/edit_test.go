@@ -0,0 +1,61 @@
+package tinabot
+
+import (
+	"testing"
+
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestEditChoice(t *testing.T) {
+	menu := tuttobene.Menu{
+		Rows: []tuttobene.MenuRow{
+			{Content: "Fusilli al pomodoro", Type: tuttobene.Primo},
+			{Content: "Scorfano con ginger lime", Type: tuttobene.Secondo},
+			{Content: "Pollo arrosto", Type: tuttobene.Secondo},
+			{Content: "Piselli", Type: tuttobene.Contorno},
+			{Content: "Patate arrosto", Type: tuttobene.Contorno},
+			{Content: "Macedonia", Type: tuttobene.Frutta},
+		},
+	}
+
+	current, _, err := parseOrder(menu, "fusilli + scorfano &amp; piselli")
+	assertEqual(t, err, nil, "")
+
+	c, _, err := editChoice(menu, current, "aggiungi", "macedonia")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, UserChoiceArray(c).String(), "Fusilli al pomodoro\nScorfano con ginger lime con Piselli\nMacedonia", "")
+
+	c, _, err = editChoice(menu, c, "togli", "piselli")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, c.String(), "Fusilli al pomodoro\nScorfano con ginger lime\nMacedonia", "")
+
+	c, _, err = editChoice(menu, c, "togli", "fusilli")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, c.String(), "Scorfano con ginger lime\nMacedonia", "")
+
+	// "con" is part of the dish name, the right split point must be found
+	c, _, err = editChoice(menu, c, "sostituisci", "scorfano con ginger lime con pollo &amp; patate")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, c.String(), "Pollo arrosto con Patate arrosto\nMacedonia", "")
+
+	c, _, err = editChoice(menu, c, "sostituisci", "patate con piselli")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, c.String(), "Pollo arrosto con Piselli\nMacedonia", "")
+
+	c, _, err = editChoice(menu, c, "sostituisci", "piselli con piselli [pochi]")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, c.String(), "Pollo arrosto con Piselli [pochi]\nMacedonia", "")
+
+	// composition rules are enforced
+	_, _, err = editChoice(menu, c, "sostituisci", "piselli con fusilli")
+	assertNotEqual(t, err, nil, "Non si può comporre un secondo con un primo")
+
+	_, _, err = editChoice(menu, c, "togli", "arrosto")
+	assertEqual(t, err, nil, "")
+
+	_, _, err = editChoice(menu, current, "togli", "o")
+	assertNotEqual(t, err, nil, "Più piatti corrispondono")
+
+	_, _, err = editChoice(menu, c, "togli", "lasagne")
+	assertNotEqual(t, err, nil, "Nessun piatto corrisponde")
+}
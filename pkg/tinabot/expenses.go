@@ -0,0 +1,224 @@
+package tinabot
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/shopspring/decimal"
+	"github.com/tealeg/xlsx"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// expenseDay is a day of the monthly expenses of a user
+type expenseDay struct {
+	Date   time.Time
+	Dishes []string
+	Mark   string // what the user ate, empty if only her guests did
+	Lunch  bool   // the user had lunch, i.e. the day counts for the meal vouchers
+	Price  decimal.Decimal
+}
+
+// monthExpenses returns the expenses of each user in the orders.
+// The lunches of the guests are charged to the host, unless they are paid by a project.
+func monthExpenses(codes *MarkCodes, orders []Order) map[User][]expenseDay {
+	exp := make(map[User][]expenseDay)
+
+	for _, o := range orders {
+		days := make(map[User]*expenseDay)
+		day := func(u User) *expenseDay {
+			if _, ok := days[u]; !ok {
+				days[u] = &expenseDay{Date: o.Timestamp, Price: decimal.Zero}
+			}
+			return days[u]
+		}
+
+		for u, choices := range o.Users {
+			var labels []string
+			for _, c := range choices {
+				labels = append(labels, c.Label())
+			}
+
+			if g, ok := o.guest(u); ok {
+				if g.Project != "" {
+					continue
+				}
+				d := day(g.Host)
+				d.Dishes = append(d.Dishes, u.Name+": "+strings.Join(labels, ", "))
+				d.Price = d.Price.Add(choices.Price())
+				continue
+			}
+
+			d := day(u)
+			d.Dishes = append(labels, d.Dishes...)
+			d.Mark = codes.Code(choices)
+			d.Lunch = d.Mark != codes.Nothing
+			d.Price = d.Price.Add(choices.Price())
+		}
+
+		for u, d := range days {
+			exp[u] = append(exp[u], *d)
+		}
+	}
+
+	for u := range exp {
+		sort.Slice(exp[u], func(i, j int) bool {
+			return exp[u][i].Date.Before(exp[u][j].Date)
+		})
+	}
+	return exp
+}
+
+// expensesTotals returns the total price and the number of lunches of the user, i.e. the meal vouchers
+func expensesTotals(days []expenseDay) (decimal.Decimal, int) {
+	total := decimal.Zero
+	lunches := 0
+	for _, d := range days {
+		total = total.Add(d.Price)
+		if d.Lunch {
+			lunches++
+		}
+	}
+	return total, lunches
+}
+
+func formatExpenses(days []expenseDay) string {
+	if len(days) == 0 {
+		return "Nessun pranzo ordinato nel mese"
+	}
+
+	var r []string
+	for _, d := range days {
+		l := fmt.Sprintf("%s %s: %s -> €%s", weekNames[d.Date.Weekday()], d.Date.Format("02/01"), strings.Join(d.Dishes, ", "), d.Price.String())
+		if d.Mark != "" {
+			l += " `" + d.Mark + "`"
+		}
+		r = append(r, l)
+	}
+
+	total, lunches := expensesTotals(days)
+	r = append(r, fmt.Sprintf("*Totale: €%s, %d pranzi*", total.String(), lunches))
+	return strings.Join(r, "\n")
+}
+
+// expensesXLSX returns the spreadsheet with the expenses of all the users, for the administration
+func expensesXLSX(exp map[User][]expenseDay) (*xlsx.File, error) {
+	file := xlsx.NewFile()
+	detail, err := file.AddSheet("Dettaglio")
+	if err != nil {
+		return nil, err
+	}
+	totals, err := file.AddSheet("Totali")
+	if err != nil {
+		return nil, err
+	}
+
+	addRow := func(sheet *xlsx.Sheet, values ...interface{}) {
+		row := sheet.AddRow()
+		for _, v := range values {
+			row.AddCell().SetValue(v)
+		}
+	}
+
+	var users []User
+	for u := range exp {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Name) < strings.ToLower(users[j].Name)
+	})
+
+	addRow(detail, "Utente", "Data", "Piatti", "Segna", "Prezzo")
+	addRow(totals, "Utente", "Pranzi", "Totale")
+	for _, u := range users {
+		for _, d := range exp[u] {
+			price, _ := d.Price.Float64()
+			addRow(detail, u.Name, d.Date.Format("02/01/2006"), strings.Join(d.Dishes, ", "), d.Mark, price)
+		}
+		total, lunches := expensesTotals(exp[u])
+		price, _ := total.Float64()
+		addRow(totals, u.Name, lunches, price)
+	}
+	return file, nil
+}
+
+// Expenses sends the user her expenses for the month in a direct message; admins can get
+// the expenses of everybody as a spreadsheet with "spese tutti"
+func (t *TinaBot) Expenses(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	fields := strings.Fields(args[1])
+	all := len(fields) > 0 && strings.ToLower(fields[0]) == "tutti"
+	if all {
+		fields = fields[1:]
+	}
+	if len(fields) > 1 {
+		bot.Message(msg.Channel, "Usa `spese [mm/aaaa]`")
+		return
+	}
+
+	if all && !isAdmin(user) {
+		bot.Message(msg.Channel, "Mi spiace, solo un amministratore può vedere le spese di tutti")
+		return
+	}
+
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	to := now
+	if len(fields) == 1 {
+		from, err = time.ParseInLocation("01/2006", fields[0], loc)
+		if err != nil {
+			bot.Message(msg.Channel, fmt.Sprintf("Mese '%s' non valido, usa il formato mm/aaaa", fields[0]))
+			return
+		}
+		to = from.AddDate(0, 1, -1)
+	}
+
+	ch := msg.Channel
+	if !strings.HasPrefix(ch, "D") {
+		_, _, ch, err = bot.Client.OpenIMChannel(user.ID)
+		if err != nil {
+			log.Println(err)
+			bot.Message(msg.Channel, "Non riesco a scriverti in privato, riprova più tardi")
+			return
+		}
+		bot.Message(msg.Channel, "Ok, ti scrivo in privato")
+	}
+
+	exp := monthExpenses(t.codes, loadArchive(t.brain, from, to))
+	month := from.Format("01/2006")
+
+	if !all {
+		bot.Message(ch, fmt.Sprintf("Ecco le tue spese del mese %s:\n%s", month, formatExpenses(exp[User{user.Name, user.ID}])))
+		return
+	}
+
+	file, err := expensesXLSX(exp)
+	if err != nil {
+		log.Println(err)
+		bot.Message(ch, "Errore nel creare il foglio delle spese: "+err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := file.Write(&buf); err != nil {
+		log.Println(err)
+		bot.Message(ch, "Errore nel creare il foglio delle spese: "+err.Error())
+		return
+	}
+
+	filename := "spese-" + from.Format("2006-01") + ".xlsx"
+	if err := bot.Upload(ch, filename, "Ecco le spese di tutti per il mese "+month, &buf); err != nil {
+		log.Println(err)
+		bot.Message(ch, "Errore nel caricare il foglio delle spese: "+err.Error())
+	}
+}
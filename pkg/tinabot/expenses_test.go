@@ -0,0 +1,43 @@
+package tinabot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestMonthExpenses(t *testing.T) {
+	var pasta, mela UserChoice
+	pasta.Add(tuttobene.MenuRow{Content: "Pasta", Type: tuttobene.Primo, Price: decimal.New(5, 0)})
+	mela.Add(tuttobene.MenuRow{Content: "Mela", Type: tuttobene.Frutta, Price: decimal.New(15, -1)})
+
+	me := User{"me", "1"}
+	o1 := NewOrder()
+	o1.Timestamp = time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	o1.Set(me, []UserChoice{pasta, mela})
+	o1.Set(User{"guest_a", ""}, []UserChoice{pasta})
+	o1.SetGuest("guest_a", Guest{Host: me})
+	o1.Set(User{"guest_b", ""}, []UserChoice{pasta})
+	o1.SetGuest("guest_b", Guest{Host: me, Project: "acme"})
+
+	o2 := NewOrder()
+	o2.Timestamp = time.Date(2026, 10, 6, 12, 0, 0, 0, time.UTC)
+	o2.Set(User{"guest_a", ""}, []UserChoice{mela})
+	o2.SetGuest("guest_a", Guest{Host: me})
+
+	exp := monthExpenses(DefaultMarkCodes(), []Order{*o2, *o1})
+	assertEqual(t, len(exp), 1, "")
+	assertEqual(t, formatExpenses(exp[me]),
+		"lunedì 05/10: Pasta, Mela, guest_a: Pasta -> €11.5 `PD`\n"+
+			"martedì 06/10: guest_a: Mela -> €1.5\n"+
+			"*Totale: €13, 1 pranzi*", "")
+
+	file, err := expensesXLSX(exp)
+	assertEqual(t, err, nil, "")
+	assertEqual(t, len(file.Sheets), 2, "")
+	assertEqual(t, len(file.Sheet["Dettaglio"].Rows), 3, "")
+	assertEqual(t, file.Sheet["Totali"].Rows[1].Cells[2].Value, "13", "")
+}
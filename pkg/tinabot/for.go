@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/nlopes/slack"
@@ -70,6 +71,133 @@ func findDishes(menu tuttobene.Menu, dish string) []tuttobene.MenuRow {
 	return matches
 }
 
+var noteRe = regexp.MustCompile(`^(.*?)\s*\[([^\[\]]*)\]$`)
+
+// splitNote splits the trailing notes between square brackets from the dish name,
+// e.g. "lasagne [senza besciamella]"; multiple notes are joined together.
+func splitNote(dish string) (string, string) {
+	dish = strings.TrimSpace(dish)
+	var notes []string
+	for {
+		m := noteRe.FindStringSubmatch(dish)
+		if m == nil {
+			break
+		}
+		if n := strings.TrimSpace(m[2]); n != "" {
+			notes = append([]string{n}, notes...)
+		}
+		dish = m[1]
+	}
+	return dish, strings.Join(notes, ", ")
+}
+
+var quantityRe = regexp.MustCompile(`^(?i)\s*(\d+)\s*x\s+(.*)$`)
+
+// maxQuantity is the maximum number of identical dishes that can be ordered at once
+const maxQuantity = 20
+
+// splitQuantity splits the "<n>x" quantity prefix from the request, e.g. "3x pasta al pomodoro"
+func splitQuantity(req string) (int, string, error) {
+	m := quantityRe.FindStringSubmatch(req)
+	if m == nil {
+		return 1, req, nil
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n < 1 || n > maxQuantity {
+		return 0, req, fmt.Errorf("Quantità '%s' non valida, puoi ordinare da 1 a %d piatti uguali\nOrdine non aggiunto!", m[1], maxQuantity)
+	}
+	return n, m[2], nil
+}
+
+// ambiguousDishError is returned by parseOrder when a dish matches more than one menu row.
+// After is the number of occurrences of Dish in the request following the ambiguous one.
+type ambiguousDishError struct {
+	Dish  string
+	Found []tuttobene.MenuRow
+	After int
+}
+
+func (e *ambiguousDishError) Error() string {
+	var matches []string
+	for _, d := range e.Found {
+		matches = append(matches, d.Content)
+	}
+
+	return fmt.Sprintf("Cercando per '%s' ho trovato i seguenti piatti:\n%s\n----\nOrdine non aggiunto, prova ad essere più preciso!", e.Dish, strings.Join(matches, "\n"))
+}
+
+// parseOrder parses an order request (dishes joined by '+' and '&') matching it against the menu.
+// It returns the user choices and a textual report of what has been found; in case of error the
+// report is still valid and can be shown before the error message.
+func parseOrder(menu tuttobene.Menu, dish string) ([]UserChoice, string, error) {
+	var choice []UserChoice
+	reply := ""
+
+	reqs := splitEsc(dish, "+")
+
+	for ri, req := range reqs {
+		qty, req, err := splitQuantity(req)
+		if err != nil {
+			return nil, reply, err
+		}
+
+		dishes := splitEsc(req, "&amp;")
+		var currChoice UserChoice
+		for di, dish := range dishes {
+			raw := dish
+			dish, note := splitNote(dish)
+
+			quoted := (len(dish) > 1 && dish[0] == '"' && dish[len(dish)-1] == '"')
+			dish = strings.Trim(dish, "\"")
+
+			found := findDishes(menu, dish)
+			nDish := len(found)
+
+			if quoted && nDish != 1 {
+				p := tuttobene.MenuRow{
+					Content:         dish,
+					Type:            tuttobene.Empty,
+					IsDailyProposal: false,
+				}
+				currChoice.Add(p)
+				currChoice.SetNote(dish, note)
+				reply = reply + fmt.Sprintf("Aggiungo testualmente: '%s'\n", currChoice.dishString(p))
+			} else if nDish == 0 {
+				return nil, reply, fmt.Errorf("Non ho trovato nulla nel menù che corrisponda a '%s'\nOrdine non aggiunto!", dish)
+			} else if nDish > 1 {
+				// the rest of the request follows the dish name in its segment
+				rest := append([]string{raw}, dishes[di+1:]...)
+				rest = append(rest, reqs[ri+1:]...)
+				after := strings.Count(strings.ToLower(strings.Join(rest, "\x00")), strings.ToLower(dish)) - 1
+				return nil, reply, &ambiguousDishError{dish, found, after}
+			} else { // nDish == 1
+				d := found[0]
+				reply = reply + "Trovato: " + d.Content + fmt.Sprintf(" (%s)\n", tuttobene.Titles[d.Type])
+				if note != "" {
+					reply = reply + fmt.Sprintf("Con la nota: %s\n", note)
+				}
+
+				err := currChoice.Add(d)
+				if err != nil {
+					return nil, reply, fmt.Errorf("Errore nella personalizzazione: %s\nOrdine non aggiunto!", err.Error())
+				}
+				currChoice.SetNote(d.Content, note)
+			}
+		}
+		if currChoice.Customized() {
+			reply = reply + "Piatto personalizzato: " + currChoice.String() + "\n"
+		}
+		if qty > 1 {
+			currChoice.Quantity = qty
+			reply = reply + fmt.Sprintf("Quantità: %d\n", qty)
+		}
+		choice = append(choice, currChoice)
+	}
+
+	return choice, reply, nil
+}
+
 func getUserInfo(api *slack.Client, user string) *slack.User {
 	if strings.HasPrefix(user, "<@") {
 		user = strings.Trim(user, "<@>")
@@ -122,9 +250,19 @@ func (t *TinaBot) For(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User,
 	}
 
 	if strings.ToLower(dish) == "niente" {
-		order := getOrder(t.brain)
-		old := order.ClearUser(destUser)
-		order.Save(t.brain)
+		var old string
+		var entry undoEntry
+		err := UpdateOrder(t.brain, func(order *Order) error {
+			entry = newUndoEntry(order, fmt.Sprintf("per %s niente", destUser.Name), destUser)
+			old = order.ClearUser(destUser)
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			t.bot.Message(msg.Channel, "Non sono riuscito a salvare l'ordine, riprova: "+err.Error())
+			return
+		}
+		pushUndo(t.brain, User{user.Name, user.ID}, entry)
 
 		t.bot.Message(msg.Channel, fmt.Sprintf("Ok, cancello ordine per %s:\n%s", destUser.Name, old))
 		if destCh != "" {
@@ -133,15 +271,18 @@ func (t *TinaBot) For(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User,
 		return
 	}
 
-	var menu tuttobene.Menu
-	err := t.brain.Get("menu", &menu)
+	menu, err := loadTodayMenu(t.brain)
 	if err != nil {
-		t.bot.Message(msg.Channel, "Nessun menù impostato!")
+		t.bot.Message(msg.Channel, err.Error())
 		return
 	}
 
-	if !menu.IsUpdated() {
-		t.bot.Message(msg.Channel, "Non puoi ordinare, il menù non è quello di oggi, riporta la data del "+menu.Date.Format("02/01/2006"))
+	// handle the incremental edit commands
+	if m := editRe.FindStringSubmatch(dish); m != nil {
+		err := t.editOrder(bot, msg.Channel, destCh, User{user.Name, user.ID}, destUser, menu, m[1], m[2])
+		if amb, ok := err.(*ambiguousDishError); ok {
+			t.askDish(msg.Channel, user, dest, dish, amb, "")
+		}
 		return
 	}
 
@@ -173,68 +314,62 @@ func (t *TinaBot) For(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User,
 			return
 		}
 	} else {
-		reqs := splitEsc(dish, "+")
-
-		for _, req := range reqs {
-			dishes := splitEsc(req, "&amp;")
-			var currChoice UserChoice
-			for _, dish := range dishes {
-				dish = strings.TrimSpace(dish)
-
-				quoted := (dish[0] == '"' && dish[len(dish)-1] == '"')
-				dish = strings.Trim(dish, "\"")
-
-				found := findDishes(menu, dish)
-				nDish := len(found)
-
-				if quoted && nDish != 1 {
-					p := tuttobene.MenuRow{
-						Content:         dish,
-						Type:            tuttobene.Empty,
-						IsDailyProposal: false,
-					}
-					reply = reply + fmt.Sprintf("Aggiungo testualmente: '%s'\n", dish)
-					currChoice.Add(p)
-				} else if nDish == 0 {
-					t.bot.Message(msg.Channel, reply+"Non ho trovato nulla nel menù che corrisponda a '"+dish+"'\nOrdine non aggiunto!")
-					return
-				} else if nDish > 1 {
-					var matches []string
-					for _, d := range found {
-						matches = append(matches, d.Content)
-					}
-
-					t.bot.Message(msg.Channel, reply+"Cercando per '"+dish+"' ho trovato i seguenti piatti:\n"+strings.Join(matches, "\n")+"\n----\nOrdine non aggiunto, prova ad essere più preciso!")
-					return
-				} else { // nDish == 1
-					d := found[0]
-					reply = reply + "Trovato: " + d.Content + fmt.Sprintf(" (%s)\n", tuttobene.Titles[d.Type])
-
-					err := currChoice.Add(d)
-					if err != nil {
-						t.bot.Message(msg.Channel, reply+"Errore nella personalizzazione: "+err.Error()+"\nOrdine non aggiunto!")
-						return
-					}
-				}
-			}
-			if currChoice.Customized() {
-				reply = reply + "Piatto personalizzato: " + currChoice.String() + "\n"
-			}
-			choice = append(choice, currChoice)
+		var err error
+		choice, reply, err = parseOrder(menu, dish)
+		if amb, ok := err.(*ambiguousDishError); ok {
+			t.askDish(msg.Channel, user, dest, dish, amb, reply)
+			return
+		} else if err != nil {
+			t.bot.Message(msg.Channel, reply+err.Error())
+			return
 		}
 	}
 
-	order := getOrder(t.brain)
-	list := order.Set(destUser, choice)
-	order.Save(t.brain)
+	var list []string
+	var entry undoEntry
+	var guest Guest
+	err = UpdateOrder(t.brain, func(order *Order) error {
+		entry = newUndoEntry(order, fmt.Sprintf("per %s %s", destUser.Name, dish), destUser)
+		list = setChoices(t.brain, t.pricing, order, destUser, User{user.Name, user.ID}, choice)
+		guest, _ = order.guest(destUser)
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		t.bot.Message(msg.Channel, "Non sono riuscito a salvare l'ordine, riprova: "+err.Error())
+		return
+	}
+	pushUndo(t.brain, User{user.Name, user.ID}, entry)
 
-	l := len(choice)
+	// the choices have been priced by setChoices
+	reply += dietWarnings(t.brain, destUser, choice)
+	reply += budgetWarning(t.brain, destUser, choice)
+
+	guestOf := ""
+	if isGuest(destUser) {
+		guestOf = " (" + guest.String() + ")"
+		if guest.Host.ID != "" && guest.Host.ID != user.ID {
+			_, _, ch, err := bot.Client.OpenIMChannel(guest.Host.ID)
+			if err != nil {
+				log.Println(err)
+			} else {
+				destCh = ch
+			}
+		}
+	}
+
+	l := 0
+	for _, ch := range choice {
+		l += ch.Count()
+	}
 	c := "o"
 	if l > 1 {
 		c = "i"
 	}
-	t.bot.Message(msg.Channel, reply+fmt.Sprintf("Ok, aggiunt%s %d piatt%s per %s", c, l, c, destUser.Name))
-	if destCh != "" {
+	t.bot.Message(msg.Channel, reply+fmt.Sprintf("Ok, aggiunt%s %d piatt%s per %s%s", c, l, c, destUser.Name, guestOf))
+	if destCh != "" && guestOf != "" {
+		t.bot.Message(destCh, fmt.Sprintf("Ti volevo informare che <@%s> ha ordinato per il tuo ospite %s i seguenti piatti:\n%s", user.ID, destUser.Name, strings.Join(list, "\n")))
+	} else if destCh != "" {
 		t.bot.Message(destCh, fmt.Sprintf("Ti volevo informare che <@%s> ha ordinato i seguenti piatti per conto tuo:\n%s", user.ID, strings.Join(list, "\n")))
 	}
 }
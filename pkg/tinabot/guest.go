@@ -0,0 +1,245 @@
+package tinabot
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// guestPrefix is the prefix of the names of the guests without a slack user
+const guestPrefix = "guest_"
+
+// Guest holds who invited a guest and, optionally, the company or project paying for her
+type Guest struct {
+	Host    User
+	Project string `json:",omitempty"`
+}
+
+func isGuest(u User) bool {
+	return u.ID == "" && strings.HasPrefix(strings.ToLower(u.Name), guestPrefix)
+}
+
+// Payer returns who pays for the guest: the project if set, otherwise the host
+func (g Guest) Payer() string {
+	if g.Project != "" {
+		return "progetto " + g.Project
+	}
+	return g.Host.Name
+}
+
+func (g Guest) String() string {
+	s := "ospite di " + g.Host.Name
+	if g.Project != "" {
+		s += ", progetto " + g.Project
+	}
+	return s
+}
+
+// GuestLunch is a lunch of a guest, saved in the guests history for the monthly reports
+type GuestLunch struct {
+	Guest   string
+	Host    User
+	Project string `json:",omitempty"`
+	Dishes  []string
+	Price   decimal.Decimal
+}
+
+func loadGuests(brain DataStore) map[string]Guest {
+	guests := make(map[string]Guest)
+	brain.Get("guests", &guests)
+	return guests
+}
+
+// guestInfo returns the registered host of the guest, or the requester if the guest is unknown
+func guestInfo(brain DataStore, name string, requester User) Guest {
+	if g, ok := loadGuests(brain)[strings.ToLower(name)]; ok {
+		return g
+	}
+	return Guest{Host: requester}
+}
+
+// setChoices prices the choices and sets them as the ones of the user in the order. For guests it
+// records the host: the registered one, the one already in the order or the requester.
+// It returns what has been ordered.
+func setChoices(brain DataStore, pricing *PricingRules, order *Order, user, requester User, choices []UserChoice) []string {
+	pricing.SetPrices(choices)
+	if isGuest(user) {
+		g := guestInfo(brain, user.Name, requester)
+		if old, ok := order.Guests[user.Name]; ok && g == (Guest{Host: requester}) {
+			// not registered, keep who ordered first for the guest
+			g = old
+		}
+		order.SetGuest(user.Name, g)
+	}
+	return order.Set(user, choices)
+}
+
+// RecordGuests saves the guests of the order in the guests history, replacing the ones
+// already saved for the same day
+func RecordGuests(brain DataStore, order *Order) error {
+	history := make(map[string][]GuestLunch)
+	brain.Get("guests_history", &history)
+
+	var lunches []GuestLunch
+	for u, choices := range order.Users {
+		g, ok := order.guest(u)
+		if !ok {
+			continue
+		}
+		var dishes []string
+		for _, c := range choices {
+			dishes = append(dishes, c.Label())
+		}
+		lunches = append(lunches, GuestLunch{u.Name, g.Host, g.Project, dishes, choices.Price()})
+	}
+
+	date := order.Timestamp.Format("2006-01-02")
+	if len(lunches) == 0 {
+		delete(history, date)
+	} else {
+		history[date] = lunches
+	}
+	return brain.Set("guests_history", history)
+}
+
+// formatGuestsMonth returns the report of the guests lunches in the month ("2006-01"),
+// grouped by who pays for them
+func formatGuestsMonth(history map[string][]GuestLunch, month string) string {
+	byPayer := make(map[string][]string)
+	totals := make(map[string]decimal.Decimal)
+	total := decimal.Zero
+
+	var dates []string
+	for d := range history {
+		if strings.HasPrefix(d, month) {
+			dates = append(dates, d)
+		}
+	}
+	sort.Strings(dates)
+
+	for _, d := range dates {
+		for _, l := range history[d] {
+			p := Guest{l.Host, l.Project}.Payer()
+			day, _ := time.Parse("2006-01-02", d)
+			byPayer[p] = append(byPayer[p], fmt.Sprintf("%s %s (ospite di %s): %s -> €%s",
+				day.Format("02/01"), l.Guest, l.Host.Name, strings.Join(l.Dishes, ", "), l.Price.String()))
+			totals[p] = totals[p].Add(l.Price)
+			total = total.Add(l.Price)
+		}
+	}
+
+	if len(byPayer) == 0 {
+		return ""
+	}
+
+	var payers []string
+	for p := range byPayer {
+		payers = append(payers, p)
+	}
+	sort.Strings(payers)
+
+	var r []string
+	for _, p := range payers {
+		r = append(r, fmt.Sprintf("*%s: €%s*", p, totals[p].String()))
+		r = append(r, byPayer[p]...)
+	}
+	r = append(r, fmt.Sprintf("*Totale ospiti: €%s*", total.String()))
+	return strings.Join(r, "\n")
+}
+
+var guestRe = regexp.MustCompile(`^(?i)(\S+)(?:\s+di\s+(\S+))?(?:\s+progetto\s+(.+))?$`)
+
+// Guests handles the guests registry and the monthly guests report
+func (t *TinaBot) Guests(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	arg := strings.TrimSpace(args[1])
+	guests := loadGuests(t.brain)
+
+	if arg == "" {
+		if len(guests) == 0 {
+			bot.Message(msg.Channel, "Non c'è nessun ospite registrato")
+			return
+		}
+		var names []string
+		for n := range guests {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var r []string
+		for _, n := range names {
+			r = append(r, n+" ("+guests[n].String()+")")
+		}
+		bot.Message(msg.Channel, "Ecco gli ospiti registrati:\n"+strings.Join(r, "\n"))
+		return
+	}
+
+	fields := strings.Fields(arg)
+	if strings.ToLower(fields[0]) == "mese" {
+		loc, err := time.LoadLocation("Europe/Rome")
+		if err != nil {
+			log.Println("LoadLocation error: ", err)
+			return
+		}
+		month := time.Now().In(loc)
+		if len(fields) > 1 {
+			month, err = time.ParseInLocation("01/2006", fields[1], loc)
+			if err != nil {
+				bot.Message(msg.Channel, fmt.Sprintf("Mese '%s' non valido, usa il formato mm/aaaa", fields[1]))
+				return
+			}
+		}
+
+		history := make(map[string][]GuestLunch)
+		t.brain.Get("guests_history", &history)
+		report := formatGuestsMonth(history, month.Format("2006-01"))
+		if report == "" {
+			bot.Message(msg.Channel, fmt.Sprintf("Nessun ospite a pranzo nel mese %s", month.Format("01/2006")))
+			return
+		}
+		bot.Message(msg.Channel, fmt.Sprintf("Ecco gli ospiti del mese %s:\n%s", month.Format("01/2006"), report))
+		return
+	}
+
+	name := strings.ToLower(fields[0])
+	if !strings.HasPrefix(name, guestPrefix) {
+		name = guestPrefix + name
+	}
+
+	if len(fields) == 2 && strings.ToLower(fields[1]) == "off" {
+		if _, ok := guests[name]; !ok {
+			bot.Message(msg.Channel, fmt.Sprintf("L'ospite %s non è registrato", name))
+			return
+		}
+		delete(guests, name)
+		t.brain.Set("guests", guests)
+		bot.Message(msg.Channel, fmt.Sprintf("Ok, ospite %s rimosso", name))
+		return
+	}
+
+	m := guestRe.FindStringSubmatch(arg)
+	if m == nil {
+		bot.Message(msg.Channel, "Non ho capito, usa `ospite guest_<nome> [di <utente>] [progetto <progetto>]`")
+		return
+	}
+
+	g := Guest{Host: User{user.Name, user.ID}, Project: strings.TrimSpace(m[3])}
+	if m[2] != "" {
+		host := getUserInfo(bot.Client, m[2])
+		if host == nil {
+			bot.Message(msg.Channel, fmt.Sprintf("Utente '%s' non trovato", m[2]))
+			return
+		}
+		g.Host = User{host.Name, host.ID}
+	}
+
+	guests[name] = g
+	t.brain.Set("guests", guests)
+	bot.Message(msg.Channel, fmt.Sprintf("Ok, %s è registrato come %s", name, g.String()))
+}
@@ -0,0 +1,83 @@
+package tinabot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestGuestInfo(t *testing.T) {
+	b := brain.NewBrainMock()
+	me := User{"me", "1"}
+	host := User{"host", "2"}
+	b.Set("guests", map[string]Guest{"guest_cliente": {host, "acme"}})
+
+	assertEqual(t, guestInfo(b, "guest_Cliente", me), Guest{host, "acme"}, "")
+	assertEqual(t, guestInfo(b, "guest_altro", me), Guest{Host: me}, "")
+	assertEqual(t, isGuest(User{"guest_altro", ""}), true, "")
+	assertEqual(t, isGuest(User{"altro", ""}), false, "")
+}
+
+func TestSetChoicesGuest(t *testing.T) {
+	b := brain.NewBrainMock()
+	me := User{"me", "1"}
+	other := User{"other", "3"}
+	host := User{"host", "2"}
+	b.Set("guests", map[string]Guest{"guest_cliente": {host, "acme"}})
+
+	var uc UserChoice
+	uc.Add(tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo, Price: decimal.New(5, 0)})
+
+	order := NewOrder()
+	guest := User{"guest_altro", ""}
+	setChoices(b, &PricingRules{}, order, guest, me, []UserChoice{uc})
+	assertEqual(t, order.Guests["guest_altro"], Guest{Host: me}, "")
+	// who edits the order later doesn't become the host
+	setChoices(b, &PricingRules{}, order, guest, other, []UserChoice{uc, uc})
+	assertEqual(t, order.Guests["guest_altro"], Guest{Host: me}, "")
+	assertEqual(t, order.Costs()["me"].String(), "10", "")
+
+	setChoices(b, &PricingRules{}, order, User{"guest_cliente", ""}, me, []UserChoice{uc})
+	assertEqual(t, order.Guests["guest_cliente"], Guest{host, "acme"}, "")
+
+	// the host is restored by undo
+	entry := newUndoEntry(order, "rmorder guest_altro", guest)
+	order.ClearUser(guest)
+	delete(order.Guests, "guest_altro")
+	entry.restore(order)
+	assertEqual(t, order.Guests["guest_altro"], Guest{Host: me}, "")
+}
+
+func TestGuestsHistory(t *testing.T) {
+	b := brain.NewBrainMock()
+	host := User{"host", "2"}
+
+	var uc UserChoice
+	uc.Add(tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo, Price: decimal.New(5, 0)})
+
+	order := NewOrder()
+	order.Timestamp = time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	order.Set(host, []UserChoice{uc})
+	order.Set(User{"guest_a", ""}, []UserChoice{uc})
+	order.SetGuest("guest_a", Guest{Host: host})
+	assertEqual(t, RecordGuests(b, order), nil, "")
+
+	order.Timestamp = time.Date(2026, 10, 6, 12, 0, 0, 0, time.UTC)
+	order.SetGuest("guest_a", Guest{host, "acme"})
+	RecordGuests(b, order)
+	// recording the same day again replaces the saved guests
+	RecordGuests(b, order)
+
+	history := make(map[string][]GuestLunch)
+	b.Get("guests_history", &history)
+	assertEqual(t, len(history), 2, "")
+	assertEqual(t, formatGuestsMonth(history, "2026-10"),
+		"*host: €5*\n05/10 guest_a (ospite di host): primo -> €5\n"+
+			"*progetto acme: €5*\n06/10 guest_a (ospite di host): primo -> €5\n"+
+			"*Totale ospiti: €10*", "")
+	assertEqual(t, formatGuestsMonth(history, "2026-09"), "", "")
+}
@@ -0,0 +1,300 @@
+package tinabot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// maxLedgerEntries is the number of movements kept in the ledger history
+const maxLedgerEntries = 500
+
+// billNote is the note of the entries recording who paid the bill of the day
+const billNote = "conto"
+
+// LedgerEntry is a movement of the ledger: the payer paid the amount, on behalf of the debtors
+type LedgerEntry struct {
+	Date   time.Time
+	Payer  string
+	Amount decimal.Decimal
+	Debts  map[string]decimal.Decimal
+	Note   string
+}
+
+// Ledger keeps track of who paid the lunches and who owes what.
+// A positive balance is a credit, a negative one is a debt.
+type Ledger struct {
+	Balances map[string]decimal.Decimal
+	Entries  []LedgerEntry
+}
+
+func (l *Ledger) add(e LedgerEntry) {
+	if l.Balances == nil {
+		l.Balances = make(map[string]decimal.Decimal)
+	}
+
+	l.Balances[e.Payer] = l.Balances[e.Payer].Add(e.Amount)
+	for u, d := range e.Debts {
+		l.Balances[u] = l.Balances[u].Sub(d)
+	}
+	for u, b := range l.Balances {
+		if b.IsZero() {
+			delete(l.Balances, u)
+		}
+	}
+
+	l.Entries = append(l.Entries, e)
+	if len(l.Entries) > maxLedgerEntries {
+		l.Entries = l.Entries[len(l.Entries)-maxLedgerEntries:]
+	}
+}
+
+// paidBy returns who paid the bill of the day, if any
+func (l *Ledger) paidBy(day time.Time) (string, bool) {
+	date := day.Format("2006-01-02")
+	for _, e := range l.Entries {
+		if e.Note == billNote && e.Date.Format("2006-01-02") == date {
+			return e.Payer, true
+		}
+	}
+	return "", false
+}
+
+func (l *Ledger) formatBalances() string {
+	var names []string
+	for u := range l.Balances {
+		names = append(names, u)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return l.Balances[names[i]].GreaterThan(l.Balances[names[j]])
+	})
+
+	var r []string
+	for _, u := range names {
+		r = append(r, formatBalance(u, l.Balances[u]))
+	}
+	return strings.Join(r, "\n")
+}
+
+func formatBalance(name string, balance decimal.Decimal) string {
+	if balance.IsPositive() {
+		return fmt.Sprintf("%s deve ricevere €%s", name, balance.StringFixed(2))
+	} else if balance.IsNegative() {
+		return fmt.Sprintf("%s deve dare €%s", name, balance.Neg().StringFixed(2))
+	}
+	return fmt.Sprintf("%s è in pari", name)
+}
+
+// Summary returns the movements since the given time, followed by the balances
+func (l *Ledger) Summary(since time.Time) string {
+	var r []string
+	for _, e := range l.Entries {
+		if e.Date.Before(since) {
+			continue
+		}
+		if e.Note == billNote {
+			r = append(r, fmt.Sprintf("%s %s ha pagato il conto: €%s", e.Date.Format("02/01"), e.Payer, e.Amount.StringFixed(2)))
+			continue
+		}
+		for u := range e.Debts {
+			r = append(r, fmt.Sprintf("%s %s ha dato €%s a %s", e.Date.Format("02/01"), e.Payer, e.Amount.StringFixed(2), u))
+		}
+	}
+	if len(r) == 0 {
+		r = append(r, "Nessun movimento")
+	}
+
+	r = append(r, "*Saldi:*")
+	if len(l.Balances) == 0 {
+		r = append(r, "Tutti in pari!")
+	} else {
+		r = append(r, l.formatBalances())
+	}
+	return strings.Join(r, "\n")
+}
+
+// LoadLedger loads the ledger from the brain
+func LoadLedger(brain DataStore) *Ledger {
+	var l Ledger
+	brain.Get("ledger", &l)
+	return &l
+}
+
+func updateLedger(brain DataStore, modify func(l *Ledger) error) error {
+	var l Ledger
+	return brain.Update("ledger", &l, func() error {
+		return modify(&l)
+	})
+}
+
+// parseAmount parses an amount of euros, e.g. "12,50" or "€12.5"
+func parseAmount(s string) (decimal.Decimal, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "€"))
+	d, err := decimal.NewFromString(strings.Replace(s, ",", ".", 1))
+	if err != nil || !d.IsPositive() {
+		return decimal.Zero, fmt.Errorf("Importo '%s' non valido", s)
+	}
+	return d, nil
+}
+
+// billEntry returns the ledger entry of the payer paying the bill of the order. Each user is
+// debited her lunch and the ones of her guests; the lunches of the projects and of the guests
+// without a host, as well as any difference between the amount and the total, are on the payer,
+// so that the balances still sum to zero.
+func billEntry(order *Order, payer string, amount decimal.Decimal) LedgerEntry {
+	debts := make(map[string]decimal.Decimal)
+	total := decimal.Zero
+	for u, choices := range order.Users {
+		account := u.Name
+		if g, ok := order.guest(u); ok && g.Project == "" {
+			account = g.Host.Name
+		} else if isGuest(u) {
+			continue
+		}
+		debts[account] = debts[account].Add(choices.Price())
+		total = total.Add(choices.Price())
+	}
+
+	debts[payer] = debts[payer].Add(amount.Sub(total))
+	if debts[payer].IsZero() {
+		delete(debts, payer)
+	}
+	return LedgerEntry{Date: order.Timestamp, Payer: payer, Amount: amount, Debts: debts, Note: billNote}
+}
+
+// Paid records that the user paid today's bill: each participant is debited her share
+func (t *TinaBot) Paid(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		bot.Message(msg.Channel, err.Error()+", usa `pagato <importo>`")
+		return
+	}
+
+	order := getOrder(t.brain)
+	if len(order.Users) == 0 {
+		bot.Message(msg.Channel, "Oggi non c'è nessun ordine da pagare")
+		return
+	}
+
+	total := decimal.Zero
+	for _, c := range order.Costs() {
+		total = total.Add(c)
+	}
+	entry := billEntry(order, user.Name, amount)
+
+	var paidBy string
+	errPaid := errors.New("already paid")
+	err = updateLedger(t.brain, func(l *Ledger) error {
+		if p, ok := l.paidBy(order.Timestamp); ok {
+			paidBy = p
+			return errPaid
+		}
+		l.add(entry)
+		return nil
+	})
+	if err == errPaid {
+		bot.Message(msg.Channel, fmt.Sprintf("Il conto di oggi è già stato pagato da %s", paidBy))
+		return
+	} else if err != nil {
+		log.Println(err)
+		bot.Message(msg.Channel, "Non sono riuscito a salvare il pagamento, riprova: "+err.Error())
+		return
+	}
+
+	var names []string
+	for u := range entry.Debts {
+		names = append(names, u)
+	}
+	sort.Strings(names)
+	var r []string
+	for _, u := range names {
+		if u != user.Name && !entry.Debts[u].IsZero() {
+			r = append(r, fmt.Sprintf("%s deve €%s", u, entry.Debts[u].StringFixed(2)))
+		}
+	}
+
+	reply := fmt.Sprintf("Ok, %s ha pagato €%s per l'ordine di oggi:\n%s", user.Name, amount.StringFixed(2), strings.Join(r, "\n"))
+	if !total.Equal(amount) {
+		reply += fmt.Sprintf("\nAttenzione: il totale dei piatti ordinati è €%s, la differenza resta a carico di chi ha pagato", total.StringFixed(2))
+	}
+	if uncharged := order.unchargedCost(); uncharged.IsPositive() {
+		reply += fmt.Sprintf("\nI pranzi dei progetti e degli ospiti senza host (€%s) restano a carico di chi ha pagato, da rimborsare a parte", uncharged.StringFixed(2))
+	}
+	bot.Message(msg.Channel, reply)
+}
+
+// Settle records that the user gave back the amount to another user
+func (t *TinaBot) Settle(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	fields := strings.Fields(args[1])
+	if len(fields) != 2 {
+		bot.Message(msg.Channel, "Usa `salda <utente> <importo>`")
+		return
+	}
+
+	to := getUserInfo(bot.Client, fields[0])
+	if to == nil {
+		bot.Message(msg.Channel, fmt.Sprintf("Utente '%s' non trovato", fields[0]))
+		return
+	}
+	if to.ID == user.ID {
+		bot.Message(msg.Channel, "Non puoi saldare un debito con te stesso")
+		return
+	}
+
+	amount, err := parseAmount(fields[1])
+	if err != nil {
+		bot.Message(msg.Channel, err.Error()+", usa `salda <utente> <importo>`")
+		return
+	}
+
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+
+	var balance decimal.Decimal
+	err = updateLedger(t.brain, func(l *Ledger) error {
+		l.add(LedgerEntry{
+			Date:   time.Now().In(loc),
+			Payer:  user.Name,
+			Amount: amount,
+			Debts:  map[string]decimal.Decimal{to.Name: amount},
+		})
+		balance = l.Balances[user.Name]
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		bot.Message(msg.Channel, "Non sono riuscito a salvare il pagamento, riprova: "+err.Error())
+		return
+	}
+
+	bot.Message(msg.Channel, fmt.Sprintf("Ok, %s ha dato €%s a %s\n%s", user.Name, amount.StringFixed(2), to.Name, formatBalance(user.Name, balance)))
+	_, _, ch, err := bot.Client.OpenIMChannel(to.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	bot.Message(ch, fmt.Sprintf("Ti volevo informare che <@%s> ha registrato di averti dato €%s", user.ID, amount.StringFixed(2)))
+}
+
+// Balance shows the balances of the ledger
+func (t *TinaBot) Balance(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	l := LoadLedger(t.brain)
+	mine := formatBalance(user.Name, l.Balances[user.Name])
+
+	if len(l.Balances) == 0 {
+		bot.Message(msg.Channel, "Tutti in pari!")
+		return
+	}
+	bot.Message(msg.Channel, fmt.Sprintf("%s\n*Saldi:*\n%s", mine, l.formatBalances()))
+}
@@ -0,0 +1,80 @@
+package tinabot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestLedger(t *testing.T) {
+	var l Ledger
+	day := time.Date(2026, 10, 5, 13, 0, 0, 0, time.UTC)
+
+	l.add(LedgerEntry{Date: day, Payer: "a", Amount: decimal.New(20, 0), Note: billNote,
+		Debts: map[string]decimal.Decimal{"a": decimal.New(5, 0), "b": decimal.New(10, 0), "c": decimal.New(5, 0)}})
+	assertEqual(t, l.Balances["a"].String(), "15", "")
+	assertEqual(t, l.Balances["b"].String(), "-10", "")
+
+	p, ok := l.paidBy(day.Add(time.Hour))
+	assertEqual(t, ok, true, "")
+	assertEqual(t, p, "a", "")
+	_, ok = l.paidBy(day.AddDate(0, 0, 1))
+	assertEqual(t, ok, false, "")
+
+	l.add(LedgerEntry{Date: day.AddDate(0, 0, 1), Payer: "c", Amount: decimal.New(5, 0),
+		Debts: map[string]decimal.Decimal{"a": decimal.New(5, 0)}})
+	_, ok = l.Balances["c"]
+	assertEqual(t, ok, false, "")
+	assertEqual(t, l.formatBalances(), "a deve ricevere €10.00\nb deve dare €10.00", "")
+
+	s := l.Summary(day.AddDate(0, 0, 1))
+	assertEqual(t, strings.Contains(s, "ha pagato il conto"), false, s)
+	assertEqual(t, strings.Contains(s, "06/10 c ha dato €5.00 a a"), true, s)
+
+	amount, err := parseAmount("€12,50")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, amount.String(), "12.5", "")
+	_, err = parseAmount("-3")
+	assertNotEqual(t, err, nil, "")
+}
+
+func TestBillEntry(t *testing.T) {
+	a := User{"a", "1"}
+	b := User{"b", "2"}
+
+	var uc UserChoice
+	uc.Add(tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo, Price: decimal.New(5, 0)})
+
+	order := NewOrder()
+	order.Set(a, []UserChoice{uc})
+	order.Set(b, []UserChoice{uc})
+	order.Set(User{"guest_host", ""}, []UserChoice{uc})
+	order.SetGuest("guest_host", Guest{Host: b})
+	order.Set(User{"guest_project", ""}, []UserChoice{uc})
+	order.SetGuest("guest_project", Guest{b, "acme"})
+	order.Set(User{"guest_nohost", ""}, []UserChoice{uc})
+	assertEqual(t, order.unchargedCost().String(), "10", "")
+
+	for _, amount := range []int64{25, 30, 22} {
+		var l Ledger
+		e := billEntry(order, "a", decimal.New(amount, 0))
+		l.add(e)
+
+		// projects and guests without a host are not accounts
+		assertEqual(t, len(e.Debts) <= 2, true, "")
+		assertEqual(t, e.Debts["b"].String(), "10", "")
+
+		sum := decimal.Zero
+		for _, v := range l.Balances {
+			sum = sum.Add(v)
+		}
+		assertEqual(t, sum.String(), "0", "")
+		assertEqual(t, l.Balances["b"].String(), "-10", "")
+		// the payer gets back only what the others owe
+		assertEqual(t, l.Balances["a"].String(), "10", "")
+	}
+}
@@ -0,0 +1,220 @@
+package tinabot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// MarkRule gives a code of the lunch sheet to the choices containing dishes of all the given types
+type MarkRule struct {
+	Types []string // type names of the dishes, as in the pricing rules
+	Code  string
+}
+
+// MarkCodes maps the orders to the codes of the lunch sheet. Each choice of a user gets the code
+// of the first matching rule, or the Default one if none matches; the codes of the choices are
+// then joined in the order of the rules. A user without choices gets the Nothing code.
+// Out is the code of who goes out for lunch with the lunch poll, Nothing if empty.
+// Valid lists the codes accepted by the "segna" command.
+type MarkCodes struct {
+	Rules   []MarkRule
+	Default string
+	Nothing string
+	Out     string `json:",omitempty"`
+	Valid   []string
+}
+
+// DefaultMarkCodes returns the codes used by the administration sheet: primi, panini and
+// contorni are P, secondi and vegetariani are S, frutta and dolci are D
+func DefaultMarkCodes() *MarkCodes {
+	return &MarkCodes{
+		Rules: []MarkRule{
+			{[]string{"primo"}, "P"},
+			{[]string{"panino"}, "P"},
+			{[]string{"secondo"}, "S"},
+			{[]string{"vegetariano"}, "S"},
+			{[]string{"contorno"}, "P"},
+			{[]string{"frutta"}, "D"},
+			{[]string{"dolce"}, "D"},
+		},
+		Default: "S",
+		Nothing: "Niente",
+		Valid:   []string{"P", "PS", "PD", "S", "SD", "D", "PSD", "Niente"},
+	}
+}
+
+// Validate checks that the type names used in the rules exist and that the codes are valid
+func (m *MarkCodes) Validate() error {
+	for _, r := range m.Rules {
+		if r.Code == "" {
+			return fmt.Errorf("manca il codice di una regola")
+		}
+		if len(r.Types) == 0 {
+			return fmt.Errorf("la regola '%s' non ha nessun tipo di piatto", r.Code)
+		}
+		for _, t := range r.Types {
+			if _, ok := dishTypeNames[strings.ToLower(t)]; !ok {
+				return fmt.Errorf("tipo di piatto '%s' sconosciuto nella regola '%s'", t, r.Code)
+			}
+		}
+	}
+	if m.Default == "" || m.Nothing == "" {
+		return fmt.Errorf("i codici Default e Nothing sono obbligatori")
+	}
+	if _, ok := m.Lookup(m.Nothing); !ok {
+		return fmt.Errorf("il codice '%s' deve essere tra quelli validi", m.Nothing)
+	}
+	if _, ok := m.Lookup(m.Out); m.Out != "" && !ok {
+		return fmt.Errorf("il codice '%s' deve essere tra quelli validi", m.Out)
+	}
+	return nil
+}
+
+// Lookup returns the valid code matching the given one, regardless of the case
+func (m *MarkCodes) Lookup(code string) (string, bool) {
+	for _, v := range m.Valid {
+		if strings.ToUpper(v) == strings.ToUpper(code) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// code returns the code of the first rule matching the choice, the default one if none matches
+func (m *MarkCodes) code(u *UserChoice) string {
+	for _, r := range m.Rules {
+		var mask uint
+		for _, t := range r.Types {
+			mask |= 1 << uint(dishTypeNames[strings.ToLower(t)])
+		}
+		if u.DishMask&mask == mask {
+			return r.Code
+		}
+	}
+	return m.Default
+}
+
+// rank returns the position of the code in the rules, used to sort the codes of the choices
+func (m *MarkCodes) rank(code string) int {
+	for i, r := range m.Rules {
+		if r.Code == code {
+			return i
+		}
+	}
+	return len(m.Rules)
+}
+
+// Code returns the code of the lunch sheet for the choices of a user
+func (m *MarkCodes) Code(choices UserChoiceArray) string {
+	var codes []string
+	for i := range choices {
+		if choices[i].DishMask != 0 {
+			codes = append(codes, m.code(&choices[i]))
+		}
+	}
+	if len(codes) == 0 {
+		return m.Nothing
+	}
+
+	sort.SliceStable(codes, func(i, j int) bool {
+		return m.rank(codes[i]) < m.rank(codes[j])
+	})
+	return strings.Join(codes, "")
+}
+
+func (m *MarkCodes) String() string {
+	var r []string
+	for _, rule := range m.Rules {
+		r = append(r, fmt.Sprintf("%s: %s", strings.Join(rule.Types, " + "), rule.Code))
+	}
+	r = append(r, fmt.Sprintf("altro: %s", m.Default))
+	r = append(r, fmt.Sprintf("nessun piatto: %s", m.Nothing))
+	if m.Out != "" {
+		r = append(r, fmt.Sprintf("pranzo fuori: %s", m.Out))
+	}
+	r = append(r, fmt.Sprintf("Codici validi: %s", strings.Join(m.Valid, ", ")))
+	return strings.Join(r, "\n")
+}
+
+// OutMark returns the code of the lunch sheet for who goes out for lunch
+func (m *MarkCodes) OutMark() string {
+	if m.Out != "" {
+		return m.Out
+	}
+	return m.Nothing
+}
+
+// LoadMarkCodes loads the mark codes from the JSON file in MARK_CODES_FILE, if set,
+// otherwise from the brain. Invalid codes are ignored and the default ones are returned.
+func LoadMarkCodes(brain DataStore) *MarkCodes {
+	m := DefaultMarkCodes()
+	var err error
+
+	if path := os.Getenv("MARK_CODES_FILE"); path != "" {
+		var data []byte
+		data, err = ioutil.ReadFile(path)
+		if err == nil {
+			m = &MarkCodes{}
+			err = json.Unmarshal(data, m)
+		}
+	} else {
+		var stored MarkCodes
+		if brain.Get("markcodes", &stored) == nil {
+			m = &stored
+		}
+	}
+
+	if err == nil {
+		err = m.Validate()
+	}
+	if err != nil {
+		log.Println("Invalid mark codes, using the default ones:", err)
+		m = DefaultMarkCodes()
+	}
+	return m
+}
+
+// markCodes shows or, for admins, sets the mark codes as JSON
+func (t *TinaBot) markCodes(msg *slackbot.BotMsg, user *slack.User, arg string) {
+	arg = strings.Trim(sanitize(arg), "`")
+
+	if arg == "" {
+		t.bot.Message(msg.Channel, "Ecco i codici del foglio dei pranzi:\n"+t.codes.String())
+		return
+	}
+
+	if !isAdmin(user) {
+		t.bot.Message(msg.Channel, "Mi spiace, solo un amministratore può modificare i codici del foglio dei pranzi")
+		return
+	}
+	if os.Getenv("MARK_CODES_FILE") != "" {
+		t.bot.Message(msg.Channel, "I codici del foglio dei pranzi sono impostati da file, non posso modificarli")
+		return
+	}
+
+	m := DefaultMarkCodes()
+	if strings.ToLower(arg) != "default" {
+		m = &MarkCodes{}
+		err := json.Unmarshal([]byte(arg), m)
+		if err == nil {
+			err = m.Validate()
+		}
+		if err != nil {
+			t.bot.Message(msg.Channel, "Codici non validi: "+err.Error())
+			return
+		}
+	}
+
+	t.brain.Set("markcodes", m)
+	t.codes = m
+	t.bot.Message(msg.Channel, "Ok, codici del foglio dei pranzi impostati:\n"+m.String())
+}
@@ -0,0 +1,63 @@
+package tinabot
+
+import (
+	"testing"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestDefaultMarkCodes(t *testing.T) {
+	primo := tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo}
+	secondo := tuttobene.MenuRow{Content: "secondo", Type: tuttobene.Secondo}
+	contorno := tuttobene.MenuRow{Content: "contorno", Type: tuttobene.Contorno}
+	frutta := tuttobene.MenuRow{Content: "frutta", Type: tuttobene.Frutta}
+	panino := tuttobene.MenuRow{Content: "panino", Type: tuttobene.Panino}
+	testo := tuttobene.MenuRow{Content: "testo", Type: tuttobene.Empty}
+
+	m := DefaultMarkCodes()
+	assertEqual(t, m.Validate(), nil, "")
+	assertEqual(t, m.Code(nil), "Niente", "")
+	assertEqual(t, m.Code(UserChoiceArray{*pricingChoice(frutta), *pricingChoice(primo)}), "PD", "")
+	assertEqual(t, m.Code(UserChoiceArray{*pricingChoice(secondo, contorno)}), "S", "")
+	assertEqual(t, m.Code(UserChoiceArray{*pricingChoice(contorno)}), "P", "")
+	assertEqual(t, m.Code(UserChoiceArray{*pricingChoice(panino), *pricingChoice(secondo), *pricingChoice(frutta)}), "PSD", "")
+	assertEqual(t, m.Code(UserChoiceArray{*pricingChoice(frutta), *pricingChoice(testo)}), "SD", "")
+	assertEqual(t, m.OutMark(), "Niente", "")
+
+	f, ok := m.Lookup("niente")
+	assertEqual(t, ok, true, "")
+	assertEqual(t, f, "Niente", "")
+	_, ok = m.Lookup("PI")
+	assertEqual(t, ok, false, "")
+}
+
+func TestCustomMarkCodes(t *testing.T) {
+	primo := tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo}
+	secondo := tuttobene.MenuRow{Content: "secondo", Type: tuttobene.Secondo}
+	contorno := tuttobene.MenuRow{Content: "contorno", Type: tuttobene.Contorno}
+	panino := tuttobene.MenuRow{Content: "panino", Type: tuttobene.Panino}
+
+	m := DefaultMarkCodes()
+	m.Rules = append([]MarkRule{{[]string{"secondo", "contorno"}, "PI"}, {[]string{"panino"}, "Panino"}}, m.Rules...)
+	m.Valid = append(m.Valid, "PI", "Panino")
+	assertEqual(t, m.Validate(), nil, "")
+
+	b := brain.NewBrainMock()
+	b.Set("markcodes", m)
+	codes := LoadMarkCodes(b)
+	assertEqual(t, codes.Code(UserChoiceArray{*pricingChoice(secondo, contorno)}), "PI", "")
+	assertEqual(t, codes.Code(UserChoiceArray{*pricingChoice(panino)}), "Panino", "")
+	assertEqual(t, codes.Code(UserChoiceArray{*pricingChoice(primo), *pricingChoice(secondo)}), "PS", "")
+	_, ok := codes.Lookup("pi")
+	assertEqual(t, ok, true, "")
+
+	// invalid codes fall back to the default ones
+	b.Set("markcodes", MarkCodes{Rules: []MarkRule{{[]string{"antipasto"}, "A"}}, Default: "S", Nothing: "Niente", Valid: []string{"Niente"}})
+	codes = LoadMarkCodes(b)
+	assertEqual(t, codes.Code(UserChoiceArray{*pricingChoice(secondo, contorno)}), "S", "")
+
+	bad := DefaultMarkCodes()
+	bad.Valid = []string{"P"}
+	assertNotEqual(t, bad.Validate(), nil, "")
+}
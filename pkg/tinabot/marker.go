@@ -0,0 +1,234 @@
+package tinabot
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/pop"
+	"github.com/tealeg/xlsx"
+)
+
+// Marker records what a user ate on the lunch sheet used by the administration
+type Marker interface {
+	Mark(user, food string) error
+}
+
+// defaultMarkPayload is the body posted by the webhook backend if MARK_PAYLOAD is not set
+const defaultMarkPayload = `{"user": "<USER>", "food": "<FOOD>", "date": "<DATE>"}`
+
+func markDate() string {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return time.Now().Format("2006-01-02")
+	}
+	return time.Now().In(loc).Format("2006-01-02")
+}
+
+// URLMarker marks the lunch with a GET on an URL with <USER> and <FOOD> placeholders
+type URLMarker struct {
+	Template string
+	Client   *http.Client
+}
+
+func (m *URLMarker) Mark(user, food string) error {
+	url := strings.Replace(m.Template, "<USER>", user, -1)
+	url = strings.Replace(url, "<FOOD>", food, -1)
+
+	var err error
+	for i := 0; i < 3; i++ {
+		var resp *http.Response
+		resp, err = m.Client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("mark URL replied %s", resp.Status)
+		}
+		log.Printf("ERROR marking user %s: %s, retrying\n", user, err.Error())
+	}
+	return err
+}
+
+// WebhookMarker marks the lunch with a POST of a JSON payload, with <USER>, <FOOD> and <DATE> placeholders
+type WebhookMarker struct {
+	URL     string
+	Payload string
+	Client  *http.Client
+}
+
+// jsonEscape escapes the string so that it can be put between quotes in a JSON document
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+func (m *WebhookMarker) Mark(user, food string) error {
+	payload := strings.Replace(m.Payload, "<USER>", jsonEscape(user), -1)
+	payload = strings.Replace(payload, "<FOOD>", jsonEscape(food), -1)
+	payload = strings.Replace(payload, "<DATE>", markDate(), -1)
+
+	resp, err := m.Client.Post(m.URL, "application/json", strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook replied %s", resp.Status)
+	}
+	return nil
+}
+
+// FileMarker appends the marks to a CSV file, or to an XLSX file if the name ends with .xlsx.
+// When a user is marked more than once in a day, the last row wins.
+type FileMarker struct {
+	Path string
+}
+
+// fileMarkerMu serializes the writes to the marks file
+var fileMarkerMu sync.Mutex
+
+func (m *FileMarker) Mark(user, food string) error {
+	fileMarkerMu.Lock()
+	defer fileMarkerMu.Unlock()
+
+	if strings.HasSuffix(strings.ToLower(m.Path), ".xlsx") {
+		return m.markXLSX(user, food)
+	}
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{markDate(), user, food})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (m *FileMarker) markXLSX(user, food string) error {
+	var file *xlsx.File
+	var sheet *xlsx.Sheet
+
+	if _, err := os.Stat(m.Path); os.IsNotExist(err) {
+		file = xlsx.NewFile()
+		sheet, err = file.AddSheet("Pranzi")
+		if err != nil {
+			return err
+		}
+		row := sheet.AddRow()
+		for _, h := range []string{"Data", "Utente", "Segna"} {
+			row.AddCell().SetString(h)
+		}
+	} else {
+		file, err = xlsx.OpenFile(m.Path)
+		if err != nil {
+			return err
+		}
+		if len(file.Sheets) == 0 {
+			return fmt.Errorf("%s has no sheets", m.Path)
+		}
+		sheet = file.Sheets[0]
+	}
+
+	row := sheet.AddRow()
+	for _, v := range []string{markDate(), user, food} {
+		row.AddCell().SetString(v)
+	}
+	return file.Save(m.Path)
+}
+
+// SQLMarker saves the marks in a table of the application database, replacing the one of the
+// same user and day
+type SQLMarker struct {
+	DB    *pop.Connection
+	Table string
+}
+
+func (m *SQLMarker) Mark(user, food string) error {
+	day := markDate()
+	return m.DB.Transaction(func(tx *pop.Connection) error {
+		err := tx.RawQuery(fmt.Sprintf("DELETE FROM %s WHERE day = ? AND username = ?", m.Table), day, user).Exec()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		return tx.RawQuery(fmt.Sprintf("INSERT INTO %s (day, username, food, created_at, updated_at) VALUES (?, ?, ?, ?, ?)", m.Table),
+			day, user, food, now, now).Exec()
+	})
+}
+
+var markDB *pop.Connection
+
+// SetMarkDB sets the database connection used by the "sql" marking backend
+func SetMarkDB(db *pop.Connection) {
+	markDB = db
+}
+
+// NewMarker returns the marking backend selected by MARK_BACKEND: "url" (the default) does a GET
+// on MARK_URL, "webhook" posts MARK_PAYLOAD to MARK_URL, "file" appends to MARK_FILE and "sql"
+// inserts in the MARK_TABLE table (lunch_marks by default).
+func NewMarker() (Marker, error) {
+	client := &http.Client{
+		Timeout: time.Duration(10 * time.Second),
+	}
+
+	switch backend := strings.ToLower(os.Getenv("MARK_BACKEND")); backend {
+	case "", "url":
+		markURL := os.Getenv("MARK_URL")
+		if markURL == "" {
+			return nil, fmt.Errorf("no mark URL found")
+		}
+		return &URLMarker{markURL, client}, nil
+
+	case "webhook":
+		markURL := os.Getenv("MARK_URL")
+		if markURL == "" {
+			return nil, fmt.Errorf("no mark URL found")
+		}
+		payload := os.Getenv("MARK_PAYLOAD")
+		if payload == "" {
+			payload = defaultMarkPayload
+		}
+		return &WebhookMarker{markURL, payload, client}, nil
+
+	case "file":
+		path := os.Getenv("MARK_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("no mark file found")
+		}
+		return &FileMarker{path}, nil
+
+	case "sql":
+		if markDB == nil {
+			return nil, fmt.Errorf("no database connection for marking")
+		}
+		table := os.Getenv("MARK_TABLE")
+		if table == "" {
+			table = "lunch_marks"
+		}
+		if strings.IndexFunc(table, func(r rune) bool {
+			return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+		}) >= 0 {
+			return nil, fmt.Errorf("invalid mark table name '%s'", table)
+		}
+		return &SQLMarker{markDB, table}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown mark backend '%s'", backend)
+	}
+}
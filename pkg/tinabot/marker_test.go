@@ -0,0 +1,111 @@
+package tinabot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tealeg/xlsx"
+)
+
+func TestURLMarker(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RawQuery
+	}))
+	defer ts.Close()
+
+	m := &URLMarker{ts.URL + "/?u=<USER>&f=<FOOD>", ts.Client()}
+	assertEqual(t, m.Mark("batt", "PS"), nil, "")
+	assertEqual(t, got, "u=batt&f=PS", "")
+}
+
+func TestWebhookMarker(t *testing.T) {
+	var got map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertEqual(t, r.Method, "POST", "")
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["user"] == "error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	m := &WebhookMarker{ts.URL, defaultMarkPayload, ts.Client()}
+	assertEqual(t, m.Mark(`ba"tt`, "PS"), nil, "")
+	assertEqual(t, got["user"], `ba"tt`, "")
+	assertEqual(t, got["food"], "PS", "")
+	assertEqual(t, got["date"], markDate(), "")
+
+	assertNotEqual(t, m.Mark("error", "PS"), nil, "")
+}
+
+func TestFileMarker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marks")
+	assertEqual(t, err, nil, "")
+	defer os.RemoveAll(dir)
+
+	m := &FileMarker{filepath.Join(dir, "marks.csv")}
+	assertEqual(t, m.Mark("batt", "PS"), nil, "")
+	assertEqual(t, m.Mark("fra", "Niente"), nil, "")
+
+	data, err := ioutil.ReadFile(m.Path)
+	assertEqual(t, err, nil, "")
+	date := markDate()
+	assertEqual(t, string(data), date+",batt,PS\n"+date+",fra,Niente\n", "")
+
+	m = &FileMarker{filepath.Join(dir, "marks.xlsx")}
+	assertEqual(t, m.Mark("batt", "PS"), nil, "")
+	assertEqual(t, m.Mark("fra", "D"), nil, "")
+
+	file, err := xlsx.OpenFile(m.Path)
+	assertEqual(t, err, nil, "")
+	rows := file.Sheets[0].Rows
+	assertEqual(t, len(rows), 3, "")
+	assertEqual(t, rows[0].Cells[1].String(), "Utente", "")
+	assertEqual(t, rows[2].Cells[1].String(), "fra", "")
+	assertEqual(t, rows[2].Cells[2].String(), "D", "")
+}
+
+func TestNewMarker(t *testing.T) {
+	for _, k := range []string{"MARK_BACKEND", "MARK_URL", "MARK_PAYLOAD", "MARK_FILE", "MARK_TABLE"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Unsetenv(k)
+	}
+
+	_, err := NewMarker()
+	assertNotEqual(t, err, nil, "")
+
+	os.Setenv("MARK_URL", "http://example.com/<USER>")
+	m, err := NewMarker()
+	assertEqual(t, err, nil, "")
+	_, ok := m.(*URLMarker)
+	assertEqual(t, ok, true, "")
+
+	os.Setenv("MARK_BACKEND", "Webhook")
+	m, err = NewMarker()
+	assertEqual(t, err, nil, "")
+	assertEqual(t, m.(*WebhookMarker).Payload, defaultMarkPayload, "")
+
+	os.Setenv("MARK_BACKEND", "file")
+	_, err = NewMarker()
+	assertNotEqual(t, err, nil, "")
+	os.Setenv("MARK_FILE", "/tmp/marks.csv")
+	m, err = NewMarker()
+	assertEqual(t, err, nil, "")
+	assertEqual(t, m.(*FileMarker).Path, "/tmp/marks.csv", "")
+
+	// no database connection in the tests
+	os.Setenv("MARK_BACKEND", "sql")
+	_, err = NewMarker()
+	assertNotEqual(t, err, nil, "")
+
+	os.Setenv("MARK_BACKEND", "carta")
+	_, err = NewMarker()
+	assertEqual(t, strings.Contains(err.Error(), "carta"), true, "")
+}
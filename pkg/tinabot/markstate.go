@@ -0,0 +1,164 @@
+package tinabot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// maxMarkAttempts is the number of times the retry job tries to mark a user before giving up
+const maxMarkAttempts = 5
+
+// Statuses of the marking of a user
+const (
+	MarkPending = "pending"
+	MarkDone    = "done"
+	MarkFailed  = "failed"
+)
+
+// MarkState is the state of the marking of a user in a day
+type MarkState struct {
+	User     User
+	Status   string
+	Food     string
+	Error    string `json:",omitempty"`
+	Attempts int
+	Updated  time.Time
+}
+
+// CanRetry returns true if the retry job should try to mark the user again
+func (s *MarkState) CanRetry() bool {
+	return s.Status == MarkFailed && s.Attempts < maxMarkAttempts
+}
+
+func (s *MarkState) String() string {
+	switch s.Status {
+	case MarkDone:
+		return fmt.Sprintf("%s: segnato `%s` alle %s", s.User.Name, s.Food, s.Updated.Format("15:04"))
+	case MarkFailed:
+		return fmt.Sprintf("%s: errore nel segnare `%s` (%d tentativi): %s", s.User.Name, s.Food, s.Attempts, s.Error)
+	}
+	return fmt.Sprintf("%s: `%s` da segnare", s.User.Name, s.Food)
+}
+
+func markStateKey(day string) string {
+	return "marks:" + day
+}
+
+// LoadMarkStates returns the marking states of the day (in the format 2006-01-02), by user ID
+func LoadMarkStates(brain DataStore, day string) map[string]MarkState {
+	states := make(map[string]MarkState)
+	brain.Get(markStateKey(day), &states)
+	return states
+}
+
+// TodayMarkStates returns the marking states of today
+func TodayMarkStates(brain DataStore) map[string]MarkState {
+	return LoadMarkStates(brain, markDate())
+}
+
+func updateMarkState(brain DataStore, day string, user User, modify func(s *MarkState)) error {
+	var states map[string]MarkState
+	return brain.Update(markStateKey(day), &states, func() error {
+		if states == nil {
+			states = make(map[string]MarkState)
+		}
+		s := states[user.ID]
+		s.User = user
+		s.Updated = time.Now()
+		modify(&s)
+		states[user.ID] = s
+		return nil
+	})
+}
+
+// MarkAndRecord marks the food for the user and persists the outcome in the brain,
+// so that the failures can be retried and reported
+func MarkAndRecord(brain DataStore, user *slack.User, food string) error {
+	day := markDate()
+	u := User{user.Name, user.ID}
+
+	err := updateMarkState(brain, day, u, func(s *MarkState) {
+		if s.Food != food {
+			s.Attempts = 0
+		}
+		s.Status = MarkPending
+		s.Food = food
+		s.Error = ""
+	})
+	if err != nil {
+		log.Println(err)
+	}
+
+	markErr := MarkUser(user, food)
+
+	err = updateMarkState(brain, day, u, func(s *MarkState) {
+		s.Attempts++
+		if markErr != nil {
+			s.Status = MarkFailed
+			s.Error = markErr.Error()
+		} else {
+			s.Status = MarkDone
+			s.Error = ""
+		}
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return markErr
+}
+
+func sortedMarkStates(states map[string]MarkState) []MarkState {
+	var r []MarkState
+	for _, s := range states {
+		r = append(r, s)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return strings.ToLower(r[i].User.Name) < strings.ToLower(r[j].User.Name)
+	})
+	return r
+}
+
+// FormatMarkStates returns the state of each user
+func FormatMarkStates(states map[string]MarkState) string {
+	if len(states) == 0 {
+		return "Oggi non ho segnato nessun pranzo"
+	}
+	var r []string
+	for _, s := range sortedMarkStates(states) {
+		r = append(r, s.String())
+	}
+	return strings.Join(r, "\n")
+}
+
+// MarkSummary returns the report for the admins listing who could not be marked and why,
+// empty if everybody has been marked
+func MarkSummary(states map[string]MarkState) string {
+	done := 0
+	var r []string
+	for _, s := range sortedMarkStates(states) {
+		if s.Status == MarkDone {
+			done++
+		} else {
+			r = append(r, s.String())
+		}
+	}
+	if len(r) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Pranzi segnati: %d su %d. Non sono riuscito a segnare:\n%s", done, len(states), strings.Join(r, "\n"))
+}
+
+// RecordMarkFailure records that the user could not be marked without trying, e.g. when she is not found on Slack
+func RecordMarkFailure(brain DataStore, user User, food string, markErr error) error {
+	return updateMarkState(brain, markDate(), user, func(s *MarkState) {
+		s.Status = MarkFailed
+		s.Food = food
+		s.Error = markErr.Error()
+		s.Attempts = maxMarkAttempts
+	})
+}
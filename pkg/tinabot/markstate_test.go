@@ -0,0 +1,65 @@
+package tinabot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/brain"
+)
+
+func TestMarkAndRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marks")
+	assertEqual(t, err, nil, "")
+	defer os.RemoveAll(dir)
+
+	for _, k := range []string{"MARK_BACKEND", "MARK_FILE"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("MARK_BACKEND", "file")
+	os.Setenv("MARK_FILE", filepath.Join(dir, "marks.csv"))
+
+	b := brain.NewBrainMock()
+	batt := &slack.User{ID: "U1", Name: "batt", Profile: slack.UserProfile{Email: "batt@develer.com"}}
+	ext := &slack.User{ID: "U2", Name: "ext", Profile: slack.UserProfile{Email: "ext@example.com"}}
+
+	assertEqual(t, MarkAndRecord(b, batt, "PS"), nil, "")
+	assertNotEqual(t, MarkAndRecord(b, ext, "S"), nil, "")
+
+	states := TodayMarkStates(b)
+	assertEqual(t, len(states), 2, "")
+	assertEqual(t, states["U1"].Status, MarkDone, "")
+	assertEqual(t, states["U1"].Food, "PS", "")
+	assertEqual(t, states["U1"].Attempts, 1, "")
+	assertEqual(t, states["U2"].Status, MarkFailed, "")
+	assertEqual(t, states["U2"].Error, "user does not have a Develer mail", "")
+
+	s := states["U2"]
+	assertEqual(t, s.CanRetry(), true, "")
+
+	summary := MarkSummary(states)
+	assertEqual(t, strings.HasPrefix(summary, "Pranzi segnati: 1 su 2"), true, summary)
+	assertEqual(t, strings.Contains(summary, "ext: errore nel segnare `S`"), true, summary)
+	assertEqual(t, strings.Contains(summary, "batt"), false, summary)
+
+	// the retries are counted until the food changes
+	for i := 1; i < maxMarkAttempts; i++ {
+		MarkAndRecord(b, ext, "S")
+	}
+	s = TodayMarkStates(b)["U2"]
+	assertEqual(t, s.Attempts, maxMarkAttempts, "")
+	assertEqual(t, s.CanRetry(), false, "")
+	MarkAndRecord(b, ext, "P")
+	assertEqual(t, TodayMarkStates(b)["U2"].Attempts, 1, "")
+
+	assertEqual(t, RecordMarkFailure(b, User{"ghost", "U3"}, "D", os.ErrNotExist), nil, "")
+	s = TodayMarkStates(b)["U3"]
+	assertEqual(t, s.CanRetry(), false, "")
+
+	// no summary if everybody has been marked
+	assertEqual(t, MarkSummary(map[string]MarkState{"U1": states["U1"]}), "", "")
+}
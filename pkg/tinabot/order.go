@@ -14,6 +14,7 @@ import (
 type DataStore interface {
 	Set(string, interface{}) error
 	Get(string, interface{}) error
+	Update(string, interface{}, func() error) error
 }
 
 // User data
@@ -47,6 +48,7 @@ type Order struct {
 	Timestamp time.Time
 	Dishes    map[string][]User        //map dishes with users
 	Users     map[User]UserChoiceArray //map each user to his/her dishes
+	Guests    map[string]Guest         `json:",omitempty"` //map guest names to their host
 }
 
 // NewOrder returns a new empty order
@@ -69,16 +71,22 @@ func (order *Order) ClearUser(user User) string {
 	var deleted []string
 
 	for _, d := range order.sorted() {
-		users := order.Dishes[d]
-		for i, u := range users {
-			if u == user {
-				deleted = append(deleted, d)
-				order.Dishes[d] = append(order.Dishes[d][:i], order.Dishes[d][i+1:]...)
-				break
+		if n := order.userCount(d, user); n > 1 {
+			deleted = append(deleted, fmt.Sprintf("%dx %s", n, d))
+		} else if n == 1 {
+			deleted = append(deleted, d)
+		}
+
+		var users []User
+		for _, u := range order.Dishes[d] {
+			if u != user {
+				users = append(users, u)
 			}
 		}
-		if len(order.Dishes[d]) == 0 {
+		if len(users) == 0 {
 			delete(order.Dishes, d)
+		} else {
+			order.Dishes[d] = users
 		}
 	}
 
@@ -125,10 +133,26 @@ func (order *Order) Load(brain DataStore) error {
 	return nil
 }
 
-// Save saves order to redis brain
-func (order *Order) Save(brain DataStore) error {
-	fmt.Println("save")
-	return brain.Set("order", *order)
+// UpdateOrder atomically changes today's order, starting from a new one if the saved order is old.
+// In case of concurrent updates modify is called again on the fresh order, so it must not have
+// side effects: the results needed afterwards must be saved in variables overwritten at every call.
+// The updated order is also saved in the archive.
+func UpdateOrder(brain DataStore, modify func(order *Order) error) error {
+	var order Order
+	err := brain.Update("order", &order, func() error {
+		if !order.IsUpdated() {
+			order = *NewOrder()
+		}
+		return modify(&order)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := archiveOrder(brain, &order); err != nil {
+		log.Println("Error archiving the order:", err)
+	}
+	return nil
 }
 
 // Set set the current order for user to her choice, returns a string array of what she ordered
@@ -136,14 +160,123 @@ func (order *Order) Set(user User, choice []UserChoice) []string {
 	order.ClearUser(user)
 	var list []string
 	for _, c := range choice {
-		order.Dishes[c.String()] = append(order.Dishes[c.String()], user)
+		if !order.hasUser(c.String(), user) {
+			order.Dishes[c.String()] = append(order.Dishes[c.String()], user)
+		}
 		order.Users[user] = append(order.Users[user], c)
-		list = append(list, c.String())
+		list = append(list, c.Label())
 	}
 
 	return list
 }
 
+func (order *Order) hasUser(dish string, user User) bool {
+	for _, u := range order.Dishes[dish] {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// userCount returns how many times the user ordered the dish
+func (order *Order) userCount(dish string, user User) int {
+	cnt := 0
+	for _, c := range order.Users[user] {
+		if c.String() == dish {
+			cnt += c.Count()
+		}
+	}
+	return cnt
+}
+
+// count returns how many times the dish has been ordered
+func (order *Order) count(dish string) int {
+	cnt := 0
+	for _, u := range order.Dishes[dish] {
+		cnt += order.userCount(dish, u)
+	}
+	return cnt
+}
+
+// SetGuest records the host of a guest
+func (order *Order) SetGuest(name string, guest Guest) {
+	if order.Guests == nil {
+		order.Guests = make(map[string]Guest)
+	}
+	order.Guests[name] = guest
+}
+
+// guest returns the host information of the user, if it is a guest
+func (order *Order) guest(user User) (Guest, bool) {
+	if !isGuest(user) {
+		return Guest{}, false
+	}
+	g, ok := order.Guests[user.Name]
+	return g, ok
+}
+
+// Payer returns who pays the lunch of the user: the project or the host for guests,
+// the user herself otherwise
+func (order *Order) Payer(user User) string {
+	if g, ok := order.guest(user); ok {
+		return g.Payer()
+	}
+	return user.Name
+}
+
+// Costs returns the total price of the order for each payer, guests costs are added to
+// the host or to the project
+func (order *Order) Costs() map[string]decimal.Decimal {
+	costs := make(map[string]decimal.Decimal)
+	for u, choices := range order.Users {
+		p := order.Payer(u)
+		costs[p] = costs[p].Add(choices.Price())
+	}
+	return costs
+}
+
+// unchargedCost returns the total price of the lunches not charged to any user: the ones paid
+// by the projects and the ones of the guests without a host
+func (order *Order) unchargedCost() decimal.Decimal {
+	total := decimal.Zero
+	for u, choices := range order.Users {
+		if g, ok := order.guest(u); isGuest(u) && (!ok || g.Project != "") {
+			total = total.Add(choices.Price())
+		}
+	}
+	return total
+}
+
+// costsReport returns a line for each payer with the total of the order, guests included,
+// sorted by payer
+func (order *Order) costsReport() []string {
+	var r []string
+	for p, c := range order.Costs() {
+		r = append(r, fmt.Sprintf("%s: €%s", p, c.StringFixed(2)))
+	}
+	sort.Strings(r)
+	return r
+}
+
+// guestsReport returns a line for each guest with host, project and price, sorted by guest name
+func (order *Order) guestsReport(withPrices bool) []string {
+	var r []string
+	for u, choices := range order.Users {
+		g, ok := order.guest(u)
+		if !ok {
+			continue
+		}
+		l := u.Name + " (" + g.String() + ")"
+		if withPrices {
+			l += " -> €" + choices.Price().String()
+		}
+		r = append(r, l)
+	}
+	sort.Strings(r)
+	return r
+}
+
 func (order *Order) String() string {
 	return order.Format(true, false)
 }
@@ -159,18 +292,25 @@ func (order *Order) Format(withUserNames, withPrices bool) string {
 	total := decimal.Zero
 
 	for _, d := range order.sorted() {
-		l := fmt.Sprintf("%d %s", len(order.Dishes[d]), d)
+		cnt := order.count(d)
+		l := fmt.Sprintf("%d %s", cnt, d)
 		if withUserNames {
 			//gather names
 			var names []string
 			for _, u := range order.Dishes[d] {
-				names = append(names, u.Name)
+				name := u.Name
+				if n := order.userCount(d, u); n > 1 {
+					name = fmt.Sprintf("%s x%d", u.Name, n)
+				}
+				if g, ok := order.guest(u); ok {
+					name += " (ospite di " + g.Host.Name + ")"
+				}
+				names = append(names, name)
 			}
 			l += " [" + strings.Join(names, ", ") + "]"
 		}
 
 		if withPrices {
-			cnt := len(order.Dishes[d])
 			mul := decimal.New(int64(cnt), 0)
 			priceFound := false
 
@@ -203,6 +343,17 @@ func (order *Order) Format(withUserNames, withPrices bool) string {
 		}
 	}
 
+	if withUserNames {
+		if guests := order.guestsReport(withPrices); len(guests) > 0 {
+			r = append(r, "*Ospiti:*")
+			r = append(r, guests...)
+			if withPrices {
+				r = append(r, "*Da pagare, con gli ospiti:*")
+				r = append(r, order.costsReport()...)
+			}
+		}
+	}
+
 	return strings.Join(r, "\n")
 }
 
@@ -1,9 +1,13 @@
 package tinabot
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/shopspring/decimal"
+
 	"github.com/develersrl/lunches/pkg/brain"
 	"github.com/develersrl/lunches/pkg/tuttobene"
 )
@@ -33,7 +37,7 @@ func TestOrder(t *testing.T) {
 	uclist2 := []UserChoice{uc3}
 	order.Set(User{"test", "123"}, uclist)
 	assertEqual(t, order.String(), "1 primo [test]\n1 secondo [test]", "")
-	assertEqual(t, order.Format(false), "1 primo\n1 secondo", "")
+	assertEqual(t, order.Format(false, false), "1 primo\n1 secondo", "")
 	order.Set(User{"test2", "456"}, uclist)
 	assertEqual(t, order.String(), "2 primo [test, test2]\n2 secondo [test, test2]", "")
 	order.Set(User{"test3", "789"}, uclist2)
@@ -46,9 +50,9 @@ func TestOrder(t *testing.T) {
 	assertEqual(t, o, "secondo2", "")
 	assertEqual(t, order.String(), "1 primo [test2]\n1 secondo [test2]", "")
 	b := brain.NewBrainMock()
-	e := order.Save(b)
+	e := b.Set("order", *order)
 	assertEqual(t, e, nil, "")
-	assertEqual(t, len(b), 1, "")
+	assertEqual(t, b.Len(), 1, "")
 	neworder := NewOrder()
 	e = neworder.Load(b)
 	assertEqual(t, e, nil, "")
@@ -57,4 +61,86 @@ func TestOrder(t *testing.T) {
 	assertEqual(t, neworder.IsUpdated(), true, "")
 	neworder.Timestamp = neworder.Timestamp.Add(24 * time.Hour)
 	assertEqual(t, neworder.IsUpdated(), false, "")
+
+	// the same dish with different notes is kept separated
+	var uc4 UserChoice
+	uc4.Add(p)
+	uc4.SetNote(p.Content, "senza formaggio")
+	order.Set(User{"test4", "000"}, []UserChoice{uc4})
+	order.Set(User{"test5", "001"}, []UserChoice{uc4})
+	assertEqual(t, order.String(), "1 primo [test2]\n2 primo [senza formaggio] [test4, test5]\n1 secondo [test2]", "")
+
+	// quantities are taken into account in totals and bill
+	p.Price = decimal.New(55, -1)
+	var uc5 UserChoice
+	uc5.Add(p)
+	uc5.Quantity = 3
+	order = NewOrder()
+	order.Set(User{"guest_test", ""}, []UserChoice{uc5})
+	order.Set(User{"test", "123"}, []UserChoice{uc5, uc5})
+	assertEqual(t, order.String(), "9 primo [guest_test x3, test x6]", "")
+	assertEqual(t, order.Bill(), "9 primo [guest_test x3, test x6] -> €49.5\n*Prezzo TOTALE: €49.5*", "")
+	o = order.ClearUser(User{"test", "123"})
+	assertEqual(t, o, "6x primo", "")
+	assertEqual(t, order.Format(false, false), "3 primo", "")
+}
+
+func TestOrderGuests(t *testing.T) {
+	p := tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo, Price: decimal.New(5, 0)}
+	var uc UserChoice
+	uc.Add(p)
+
+	host := User{"host", "123"}
+	order := NewOrder()
+	order.Set(host, []UserChoice{uc})
+	order.Set(User{"guest_a", ""}, []UserChoice{uc})
+	order.SetGuest("guest_a", Guest{Host: host})
+	uc.Quantity = 2
+	order.Set(User{"guest_b", ""}, []UserChoice{uc})
+	order.SetGuest("guest_b", Guest{Host: host, Project: "acme"})
+
+	assertEqual(t, order.Format(true, false), "4 primo [host, guest_a (ospite di host), guest_b x2 (ospite di host)]\n*Ospiti:*\nguest_a (ospite di host)\nguest_b (ospite di host, progetto acme)", "")
+	assertEqual(t, order.Format(false, true), "4 primo -> €20\n*Prezzo TOTALE: €20*", "")
+
+	costs := order.Costs()
+	assertEqual(t, costs["host"].String(), "10", "")
+	assertEqual(t, costs["progetto acme"].String(), "10", "")
+	assertEqual(t, order.Payer(User{"guest_c", ""}), "guest_c", "")
+}
+
+func TestUpdateOrderConcurrent(t *testing.T) {
+	b := brain.NewBrainMock()
+	p := tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo}
+	var uc UserChoice
+	uc.Add(p)
+
+	const n = 40
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := User{fmt.Sprintf("user%d", i), fmt.Sprintf("%d", i)}
+			err := UpdateOrder(b, func(order *Order) error {
+				order.Set(u, []UserChoice{uc})
+				return nil
+			})
+			assertEqual(t, err, nil, "")
+		}(i)
+	}
+	wg.Wait()
+
+	var order Order
+	assertEqual(t, order.Load(b), nil, "")
+	assertEqual(t, len(order.Users), n, "")
+	assertEqual(t, order.Format(false, false), fmt.Sprintf("%d primo", n), "")
+
+	// an error in modify leaves the order untouched
+	err := UpdateOrder(b, func(order *Order) error {
+		order.ClearUser(User{"user0", "0"})
+		return fmt.Errorf("abort")
+	})
+	assertEqual(t, err.Error(), "abort", "")
+	order.Load(b)
+	assertEqual(t, len(order.Users), n, "")
 }
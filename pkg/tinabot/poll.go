@@ -0,0 +1,389 @@
+package tinabot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+const pollCallback = "lunch_poll"
+
+// defaultPollClose is when the lunch poll closes if no time is given
+const defaultPollClose = "11:45"
+
+// maxPollOptions is the number of buttons Slack shows in a message
+const maxPollOptions = 5
+
+// PollVote is the vote of a user in the lunch poll
+type PollVote struct {
+	Name   string
+	Option int
+}
+
+// LunchPoll is a poll to choose where to go out for lunch instead of ordering
+type LunchPoll struct {
+	Date      string // 2006-01-02
+	Channel   string
+	Options   []string
+	Closes    time.Time
+	CreatedBy User
+	Votes     map[string]PollVote // by user ID
+	Closed    bool
+}
+
+// IsOpen returns true if the poll is accepting votes at the given time
+func (p *LunchPoll) IsOpen(now time.Time) bool {
+	return !p.Closed && p.Date == now.Format("2006-01-02") && now.Before(p.Closes)
+}
+
+// counts returns the number of votes of each option
+func (p *LunchPoll) counts() []int {
+	counts := make([]int, len(p.Options))
+	for _, v := range p.Votes {
+		if v.Option >= 0 && v.Option < len(counts) {
+			counts[v.Option]++
+		}
+	}
+	return counts
+}
+
+// Winner returns the index of the option with the most votes, the first one in case of a tie,
+// -1 if nobody voted
+func (p *LunchPoll) Winner() int {
+	winner, max := -1, 0
+	for i, c := range p.counts() {
+		if c > max {
+			winner, max = i, c
+		}
+	}
+	return winner
+}
+
+// Attendees returns the names of the users who voted the winner option, sorted
+func (p *LunchPoll) Attendees() []string {
+	winner := p.Winner()
+	var r []string
+	for _, v := range p.Votes {
+		if v.Option == winner {
+			r = append(r, v.Name)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+func (p *LunchPoll) String() string {
+	var r []string
+	counts := p.counts()
+	for i, o := range p.Options {
+		var names []string
+		for _, v := range p.Votes {
+			if v.Option == i {
+				names = append(names, v.Name)
+			}
+		}
+		sort.Strings(names)
+		line := fmt.Sprintf("*%s*: %d", o, counts[i])
+		if len(names) > 0 {
+			line += " (" + strings.Join(names, ", ") + ")"
+		}
+		r = append(r, line)
+	}
+	return strings.Join(r, "\n")
+}
+
+// Result returns the announcement of the result of the poll
+func (p *LunchPoll) Result() string {
+	winner := p.Winner()
+	if winner < 0 {
+		return "Sondaggio pranzo chiuso: nessuno ha votato, si ordina dal Tuttobene come sempre."
+	}
+	return fmt.Sprintf("Sondaggio pranzo chiuso, si va a *%s* con: %s\n%s",
+		p.Options[winner], strings.Join(p.Attendees(), ", "), p.String())
+}
+
+// LoadPoll returns the lunch poll, nil if there is none
+func LoadPoll(brain DataStore) *LunchPoll {
+	var p LunchPoll
+	if brain.Get("lunchpoll", &p) != nil || p.Date == "" {
+		return nil
+	}
+	return &p
+}
+
+// updatePoll atomically modifies the lunch poll
+func updatePoll(brain DataStore, modify func(p *LunchPoll) error) error {
+	var p LunchPoll
+	return brain.Update("lunchpoll", &p, func() error {
+		return modify(&p)
+	})
+}
+
+var errPollClosed = errors.New("Il sondaggio è chiuso, non si può più votare")
+
+// votePoll records the vote of the user, voting again the same option withdraws the vote.
+// It returns the option voted, empty if the vote has been withdrawn.
+func votePoll(brain DataStore, date string, user User, option int, now time.Time) (string, error) {
+	voted := ""
+	err := updatePoll(brain, func(p *LunchPoll) error {
+		if p.Date != date || !p.IsOpen(now) {
+			return errPollClosed
+		}
+		if option < 0 || option >= len(p.Options) {
+			return fmt.Errorf("Opzione %d inesistente", option)
+		}
+		if p.Votes == nil {
+			p.Votes = make(map[string]PollVote)
+		}
+		if v, ok := p.Votes[user.ID]; ok && v.Option == option {
+			delete(p.Votes, user.ID)
+			voted = ""
+			return nil
+		}
+		p.Votes[user.ID] = PollVote{user.Name, option}
+		voted = p.Options[option]
+		return nil
+	})
+	return voted, err
+}
+
+// ClosePoll closes the lunch poll of today if its time has come, or if force is set.
+// It returns the poll just closed, nil if there was nothing to announce.
+func ClosePoll(brain DataStore, now time.Time, force bool) (*LunchPoll, error) {
+	var closed *LunchPoll
+	err := updatePoll(brain, func(p *LunchPoll) error {
+		closed = nil
+		if p.Date == "" || p.Closed || (!force && now.Before(p.Closes)) {
+			return nil
+		}
+		if p.Date != now.Format("2006-01-02") {
+			// a poll of another day, too late to announce it
+			p.Closed = true
+			return nil
+		}
+		p.Closed = true
+		c := *p
+		closed = &c
+		return nil
+	})
+	return closed, err
+}
+
+// PollAttendees returns the IDs of the users going out for lunch on the day, according to the
+// lunch poll closed or past its closing time
+func PollAttendees(brain DataStore, day time.Time) []string {
+	p := LoadPoll(brain)
+	if p == nil || p.Date != day.Format("2006-01-02") || (!p.Closed && day.Before(p.Closes)) {
+		return nil
+	}
+	winner := p.Winner()
+	var r []string
+	for id, v := range p.Votes {
+		if v.Option == winner {
+			r = append(r, id)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// parsePollOptions parses a comma separated list of options
+func parsePollOptions(s string) ([]string, error) {
+	var options []string
+	for _, o := range strings.Split(s, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			options = append(options, o)
+		}
+	}
+	if len(options) < 2 {
+		return nil, fmt.Errorf("Servono almeno due opzioni, separate da virgola")
+	}
+	if len(options) > maxPollOptions {
+		return nil, fmt.Errorf("Al massimo %d opzioni", maxPollOptions)
+	}
+	return options, nil
+}
+
+// Poll handles the lunch poll: "sondaggio pranzo [alle <orario>] [<opzioni>]" starts it,
+// "sondaggio" shows it, "sondaggio chiudi" closes it and "sondaggio opzioni [<opzioni>]"
+// shows or sets the default options
+func (t *TinaBot) Poll(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+	now := time.Now().In(loc)
+
+	fields := strings.Fields(sanitize(args[1]))
+	if len(fields) == 0 {
+		p := LoadPoll(t.brain)
+		if p == nil || p.Date != now.Format("2006-01-02") {
+			bot.Message(msg.Channel, "Oggi non c'è nessun sondaggio pranzo")
+			return
+		}
+		state := "chiuso"
+		if p.IsOpen(now) {
+			state = "aperto fino alle " + p.Closes.Format("15:04")
+		}
+		bot.Message(msg.Channel, fmt.Sprintf("Sondaggio pranzo %s:\n%s", state, p.String()))
+		return
+	}
+
+	rest := strings.TrimSpace(strings.Join(fields[1:], " "))
+	switch strings.ToLower(fields[0]) {
+	case "opzioni":
+		if rest == "" {
+			var options []string
+			t.brain.Get("lunchpoll_options", &options)
+			if len(options) == 0 {
+				bot.Message(msg.Channel, "Nessuna opzione impostata, usa `sondaggio opzioni <opzione>, <opzione>...`")
+				return
+			}
+			bot.Message(msg.Channel, "Opzioni del sondaggio pranzo: "+strings.Join(options, ", "))
+			return
+		}
+		options, err := parsePollOptions(rest)
+		if err != nil {
+			bot.Message(msg.Channel, err.Error())
+			return
+		}
+		t.brain.Set("lunchpoll_options", options)
+		bot.Message(msg.Channel, "Ok, opzioni del sondaggio pranzo: "+strings.Join(options, ", "))
+
+	case "chiudi":
+		p := LoadPoll(t.brain)
+		if p == nil || p.Closed || p.Date != now.Format("2006-01-02") {
+			bot.Message(msg.Channel, "Non c'è nessun sondaggio pranzo aperto")
+			return
+		}
+		if p.CreatedBy.ID != user.ID && !isAdmin(user) {
+			bot.Message(msg.Channel, "Solo chi ha aperto il sondaggio o un amministratore può chiuderlo")
+			return
+		}
+		closed, err := ClosePoll(t.brain, now, true)
+		if err != nil {
+			log.Println(err)
+			bot.Message(msg.Channel, "Non sono riuscito a chiudere il sondaggio, riprova: "+err.Error())
+			return
+		}
+		if closed != nil {
+			bot.Message(closed.Channel, closed.Result())
+		}
+
+	case "pranzo":
+		t.startPoll(msg, user, rest, now)
+
+	default:
+		bot.Message(msg.Channel, "Usa `sondaggio pranzo [alle <orario>] [<opzioni>]`, `sondaggio chiudi` oppure `sondaggio opzioni [<opzioni>]`")
+	}
+}
+
+func (t *TinaBot) startPoll(msg *slackbot.BotMsg, user *slack.User, arg string, now time.Time) {
+	closes := defaultPollClose
+	if fields := strings.Fields(arg); len(fields) >= 2 && strings.ToLower(fields[0]) == "alle" {
+		at, ok := parseRemindTime(fields[1])
+		if !ok {
+			t.bot.Message(msg.Channel, fmt.Sprintf("Orario '%s' non valido", fields[1]))
+			return
+		}
+		closes = at
+		arg = strings.TrimSpace(strings.Join(fields[2:], " "))
+	}
+
+	var options []string
+	if arg != "" {
+		var err error
+		options, err = parsePollOptions(arg)
+		if err != nil {
+			t.bot.Message(msg.Channel, err.Error())
+			return
+		}
+	} else {
+		t.brain.Get("lunchpoll_options", &options)
+		if len(options) == 0 {
+			t.bot.Message(msg.Channel, "Non ci sono opzioni impostate, indicale separate da virgola oppure usa `sondaggio opzioni`")
+			return
+		}
+	}
+
+	closeAt := remindAt(closes, now)
+	if !closeAt.After(now) {
+		t.bot.Message(msg.Channel, fmt.Sprintf("Le %s sono già passate!", closes))
+		return
+	}
+
+	errOpen := errors.New("C'è già un sondaggio pranzo aperto, chiudilo con `sondaggio chiudi`")
+	p := LunchPoll{
+		Date:      now.Format("2006-01-02"),
+		Channel:   msg.Channel,
+		Options:   options,
+		Closes:    closeAt,
+		CreatedBy: User{user.Name, user.ID},
+	}
+	err := updatePoll(t.brain, func(old *LunchPoll) error {
+		if old.IsOpen(now) {
+			return errOpen
+		}
+		*old = p
+		return nil
+	})
+	if err == errOpen {
+		t.bot.Message(msg.Channel, err.Error())
+		return
+	} else if err != nil {
+		log.Println(err)
+		t.bot.Message(msg.Channel, "Non sono riuscito a salvare il sondaggio, riprova: "+err.Error())
+		return
+	}
+
+	var buttons []slack.AttachmentAction
+	for i, o := range options {
+		buttons = append(buttons, slack.AttachmentAction{
+			Name:  "vote",
+			Text:  o,
+			Value: p.Date + "|" + strconv.Itoa(i),
+		})
+	}
+	t.bot.MessageWithButtons(msg.Channel, fmt.Sprintf("%s propone di andare a pranzo fuori! Dove andiamo? Il sondaggio chiude alle %s, clicca di nuovo per togliere il voto.",
+		user.Name, closes), pollCallback, buttons...)
+}
+
+func (t *TinaBot) votePoll(bot *slackbot.Bot, cb *slack.InteractionCallback) {
+	if len(cb.Actions) == 0 {
+		return
+	}
+	v := strings.SplitN(cb.Actions[0].Value, "|", 2)
+	if len(v) != 2 {
+		return
+	}
+	option, err := strconv.Atoi(v[1])
+	if err != nil {
+		return
+	}
+
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+
+	reply := ""
+	voted, err := votePoll(t.brain, v[0], User{cb.User.Name, cb.User.ID}, option, time.Now().In(loc))
+	if err != nil {
+		reply = err.Error()
+	} else if voted != "" {
+		reply = "Ok, hai votato " + voted
+	} else {
+		reply = "Ok, ho tolto il tuo voto"
+	}
+	bot.Client.PostEphemeral(cb.Channel.ID, cb.User.ID, slack.MsgOptionText(reply, false))
+}
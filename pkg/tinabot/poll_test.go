@@ -0,0 +1,91 @@
+package tinabot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+)
+
+func TestParsePollOptions(t *testing.T) {
+	options, err := parsePollOptions(" pizzeria, cinese ,, kebab")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, strings.Join(options, "|"), "pizzeria|cinese|kebab", "")
+
+	_, err = parsePollOptions("pizzeria")
+	assertNotEqual(t, err, nil, "")
+	_, err = parsePollOptions("a, b, c, d, e, f")
+	assertNotEqual(t, err, nil, "")
+}
+
+func TestLunchPoll(t *testing.T) {
+	b := brain.NewBrainMock()
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	date := now.Format("2006-01-02")
+
+	b.Set("lunchpoll", LunchPoll{
+		Date:    date,
+		Channel: "C1",
+		Options: []string{"pizzeria", "cinese"},
+		Closes:  now.Add(time.Hour),
+	})
+
+	voted, err := votePoll(b, date, User{"bob", "U1"}, 1, now)
+	assertEqual(t, err, nil, "")
+	assertEqual(t, voted, "cinese", "")
+	votePoll(b, date, User{"alice", "U2"}, 0, now)
+	votePoll(b, date, User{"carl", "U3"}, 1, now)
+
+	// voting again the same option withdraws the vote
+	voted, _ = votePoll(b, date, User{"carl", "U3"}, 1, now)
+	assertEqual(t, voted, "", "")
+	votePoll(b, date, User{"carl", "U3"}, 1, now)
+
+	_, err = votePoll(b, date, User{"bob", "U1"}, 2, now)
+	assertNotEqual(t, err, nil, "")
+	_, err = votePoll(b, "2026-10-18", User{"bob", "U1"}, 0, now)
+	assertEqual(t, err, errPollClosed, "")
+
+	// not closed before its time
+	p, err := ClosePoll(b, now.Add(30*time.Minute), false)
+	assertEqual(t, err, nil, "")
+	assertEqual(t, p == nil, true, "")
+	assertEqual(t, len(PollAttendees(b, now)), 0, "")
+
+	// past its closing time the poll counts as closed, even if nobody closed it
+	assertEqual(t, strings.Join(PollAttendees(b, now.Add(time.Hour)), ","), "U1,U3", "")
+
+	p, _ = ClosePoll(b, now.Add(time.Hour), false)
+	assertEqual(t, p.Options[p.Winner()], "cinese", "")
+	assertEqual(t, strings.Join(p.Attendees(), ","), "bob,carl", "")
+	assertEqual(t, strings.Join(PollAttendees(b, now), ","), "U1,U3", "")
+	assertEqual(t, len(PollAttendees(b, now.AddDate(0, 0, 1))), 0, "")
+
+	// closed only once
+	p, _ = ClosePoll(b, now.Add(2*time.Hour), false)
+	assertEqual(t, p == nil, true, "")
+	_, err = votePoll(b, date, User{"dave", "U4"}, 0, now)
+	assertEqual(t, err, errPollClosed, "")
+}
+
+func TestLunchPollWinner(t *testing.T) {
+	p := LunchPoll{Options: []string{"a", "b", "c"}}
+	assertEqual(t, p.Winner(), -1, "")
+	assertEqual(t, strings.HasPrefix(p.Result(), "Sondaggio pranzo chiuso: nessuno"), true, "")
+
+	// ties go to the first option
+	p.Votes = map[string]PollVote{"U1": {"bob", 2}, "U2": {"alice", 1}}
+	assertEqual(t, p.Winner(), 1, "")
+}
+
+func TestOutMark(t *testing.T) {
+	m := DefaultMarkCodes()
+	assertEqual(t, m.OutMark(), "Niente", "")
+
+	m.Out = "Fuori"
+	assertNotEqual(t, m.Validate(), nil, "")
+	m.Valid = append(m.Valid, "Fuori")
+	assertEqual(t, m.Validate(), nil, "")
+	assertEqual(t, m.OutMark(), "Fuori", "")
+}
@@ -0,0 +1,197 @@
+package tinabot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+// dishTypeNames are the names of the menu row types used in the pricing rules
+var dishTypeNames = map[string]tuttobene.MenuRowType{
+	"testuale":    tuttobene.Empty,
+	"primo":       tuttobene.Primo,
+	"secondo":     tuttobene.Secondo,
+	"contorno":    tuttobene.Contorno,
+	"vegetariano": tuttobene.Vegetariano,
+	"frutta":      tuttobene.Frutta,
+	"dolce":       tuttobene.Dolce,
+	"panino":      tuttobene.Panino,
+}
+
+// ComboRule gives a fixed price to the choices made exactly of dishes of the given types
+type ComboRule struct {
+	Name          string
+	Types         []string        // type names of the dishes, e.g. ["secondo", "contorno"]
+	DailyProposal bool            `json:",omitempty"` // only if one of the dishes is the daily proposal
+	Price         decimal.Decimal // fixed price of the combo
+}
+
+// PricingRules prices the user choices. The first matching combo wins; otherwise the base price
+// is the highest among the dishes, plus ExtraContorno for each contorno beyond the IncludedContorni
+// of a composed plate. The zero value gives the base price only.
+type PricingRules struct {
+	Combos           []ComboRule     `json:",omitempty"`
+	IncludedContorni int             `json:",omitempty"`
+	ExtraContorno    decimal.Decimal `json:",omitempty"`
+}
+
+// Validate checks that the type names used in the rules exist
+func (r *PricingRules) Validate() error {
+	for _, c := range r.Combos {
+		if len(c.Types) == 0 {
+			return fmt.Errorf("la combinazione '%s' non ha nessun tipo di piatto", c.Name)
+		}
+		for _, t := range c.Types {
+			if _, ok := dishTypeNames[strings.ToLower(t)]; !ok {
+				return fmt.Errorf("tipo di piatto '%s' sconosciuto nella combinazione '%s'", t, c.Name)
+			}
+		}
+	}
+	if r.IncludedContorni < 0 || r.ExtraContorno.IsNegative() {
+		return fmt.Errorf("il supplemento per i contorni non può essere negativo")
+	}
+	return nil
+}
+
+func (c *ComboRule) matches(u *UserChoice) bool {
+	if len(c.Types) != len(u.Dishes) {
+		return false
+	}
+
+	want := make(map[tuttobene.MenuRowType]int)
+	for _, t := range c.Types {
+		want[dishTypeNames[strings.ToLower(t)]]++
+	}
+	daily := false
+	for _, d := range u.Dishes {
+		want[d.Type]--
+		daily = daily || d.IsDailyProposal
+	}
+	for _, n := range want {
+		if n != 0 {
+			return false
+		}
+	}
+	return daily || !c.DailyProposal
+}
+
+// Price returns the price of a single dish of the choice, regardless of its quantity
+func (r *PricingRules) Price(u *UserChoice) decimal.Decimal {
+	for _, c := range r.Combos {
+		if c.matches(u) {
+			return c.Price
+		}
+	}
+
+	p := decimal.Zero
+	contorni := 0
+	for _, d := range u.Dishes {
+		p = decimal.Max(p, d.Price)
+		if d.Type == tuttobene.Contorno {
+			contorni++
+		}
+	}
+
+	if u.Customized() && contorni > r.IncludedContorni {
+		p = p.Add(r.ExtraContorno.Mul(decimal.New(int64(contorni-r.IncludedContorni), 0)))
+	}
+	return p
+}
+
+func (r *PricingRules) String() string {
+	var out []string
+	for _, c := range r.Combos {
+		l := fmt.Sprintf("%s (%s", c.Name, strings.Join(c.Types, " + "))
+		if c.DailyProposal {
+			l += ", proposta del giorno"
+		}
+		out = append(out, l+fmt.Sprintf("): €%s", c.Price.StringFixed(2)))
+	}
+	if r.ExtraContorno.IsPositive() {
+		out = append(out, fmt.Sprintf("Supplemento per ogni contorno oltre %d: €%s", r.IncludedContorni, r.ExtraContorno.StringFixed(2)))
+	}
+	if len(out) == 0 {
+		return "Il prezzo di un piatto è quello più alto tra le sue portate"
+	}
+	sort.Strings(out)
+	return strings.Join(out, "\n")
+}
+
+// SetPrices sets the unit price of the choices according to the rules
+func (r *PricingRules) SetPrices(choices []UserChoice) {
+	for i := range choices {
+		choices[i].UnitPrice = r.Price(&choices[i])
+	}
+}
+
+// LoadPricingRules loads the pricing rules from the JSON file in PRICING_RULES_FILE, if set,
+// otherwise from the brain. Invalid rules are ignored and the default ones are returned.
+func LoadPricingRules(brain DataStore) *PricingRules {
+	var r PricingRules
+	var err error
+
+	if path := os.Getenv("PRICING_RULES_FILE"); path != "" {
+		var data []byte
+		data, err = ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &r)
+		}
+	} else {
+		brain.Get("pricing", &r)
+	}
+
+	if err == nil {
+		err = r.Validate()
+	}
+	if err != nil {
+		log.Println("Invalid pricing rules, using the default ones:", err)
+		r = PricingRules{}
+	}
+	return &r
+}
+
+// Pricing shows or, for admins, sets the pricing rules as JSON
+func (t *TinaBot) Pricing(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	arg := strings.TrimSpace(sanitize(args[1]))
+	arg = strings.Trim(arg, "`")
+
+	if arg == "" {
+		bot.Message(msg.Channel, "Ecco le regole per i prezzi:\n"+t.pricing.String())
+		return
+	}
+
+	if !isAdmin(user) {
+		bot.Message(msg.Channel, "Mi spiace, solo un amministratore può modificare le regole dei prezzi")
+		return
+	}
+	if os.Getenv("PRICING_RULES_FILE") != "" {
+		bot.Message(msg.Channel, "Le regole dei prezzi sono impostate da file, non posso modificarle")
+		return
+	}
+
+	var r PricingRules
+	if strings.ToLower(arg) != "default" {
+		err := json.Unmarshal([]byte(arg), &r)
+		if err == nil {
+			err = r.Validate()
+		}
+		if err != nil {
+			bot.Message(msg.Channel, "Regole non valide: "+err.Error())
+			return
+		}
+	}
+
+	t.brain.Set("pricing", r)
+	t.pricing = &r
+	bot.Message(msg.Channel, "Ok, regole per i prezzi impostate:\n"+r.String())
+}
@@ -0,0 +1,89 @@
+package tinabot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func pricingChoice(dishes ...tuttobene.MenuRow) *UserChoice {
+	var u UserChoice
+	for _, d := range dishes {
+		u.Add(d)
+	}
+	return &u
+}
+
+func TestPricingDefault(t *testing.T) {
+	secondo := tuttobene.MenuRow{Content: "secondo", Type: tuttobene.Secondo, Price: decimal.New(7, 0)}
+	contorno := tuttobene.MenuRow{Content: "contorno", Type: tuttobene.Contorno, Price: decimal.New(3, 0)}
+	contorno2 := tuttobene.MenuRow{Content: "contorno2", Type: tuttobene.Contorno, Price: decimal.New(35, -1)}
+	primo := tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo}
+
+	// the default rules take the highest price among the dishes
+	var r PricingRules
+	assertEqual(t, r.Price(pricingChoice(secondo)).String(), "7", "")
+	assertEqual(t, r.Price(pricingChoice(secondo, contorno, contorno2)).String(), "7", "")
+	assertEqual(t, r.Price(pricingChoice(contorno, contorno2)).String(), "3.5", "")
+	assertEqual(t, r.Price(pricingChoice(primo)).String(), "0", "")
+	assertEqual(t, pricingChoice(secondo, contorno).Price().String(), "7", "")
+}
+
+func TestPricingRules(t *testing.T) {
+	secondo := tuttobene.MenuRow{Content: "secondo", Type: tuttobene.Secondo, Price: decimal.New(7, 0)}
+	proposta := tuttobene.MenuRow{Content: "proposta", Type: tuttobene.Secondo, IsDailyProposal: true, Price: decimal.New(7, 0)}
+	contorno := tuttobene.MenuRow{Content: "contorno", Type: tuttobene.Contorno, Price: decimal.New(3, 0)}
+	frutta := tuttobene.MenuRow{Content: "frutta", Type: tuttobene.Frutta, Price: decimal.New(2, 0)}
+
+	r := PricingRules{
+		Combos: []ComboRule{
+			{Name: "proposta con contorno", Types: []string{"Contorno", "secondo"}, DailyProposal: true, Price: decimal.New(8, 0)},
+		},
+		IncludedContorni: 1,
+		ExtraContorno:    decimal.New(15, -1),
+	}
+	assertEqual(t, r.Validate(), nil, "")
+	assertEqual(t, r.Price(pricingChoice(proposta, contorno)).String(), "8", "")
+	assertEqual(t, r.Price(pricingChoice(secondo, contorno)).String(), "7", "")
+	assertEqual(t, r.Price(pricingChoice(secondo, contorno, contorno)).String(), "8.5", "")
+	assertEqual(t, r.Price(pricingChoice(proposta, contorno, contorno, contorno)).String(), "10", "")
+	assertEqual(t, r.Price(pricingChoice(frutta)).String(), "2", "")
+
+	choices := []UserChoice{*pricingChoice(proposta, contorno), *pricingChoice(frutta)}
+	r.SetPrices(choices)
+	assertEqual(t, UserChoiceArray(choices).Price().String(), "10", "")
+
+	// the price is the one set when the choice was ordered, even if the rules change later
+	order := NewOrder()
+	setChoices(brain.NewBrainMock(), &r, order, User{"me", "1"}, User{"me", "1"}, []UserChoice{*pricingChoice(secondo, contorno, contorno)})
+	r.ExtraContorno = decimal.New(5, 0)
+	assertEqual(t, order.Costs()["me"].String(), "8.5", "")
+
+	bad := PricingRules{Combos: []ComboRule{{Name: "x", Types: []string{"antipasto"}}}}
+	assertNotEqual(t, bad.Validate(), nil, "")
+}
+
+func TestLoadPricingRules(t *testing.T) {
+	b := brain.NewBrainMock()
+	b.Set("pricing", PricingRules{ExtraContorno: decimal.New(1, 0)})
+	assertEqual(t, LoadPricingRules(b).ExtraContorno.String(), "1", "")
+
+	// invalid rules fall back to the default ones
+	b.Set("pricing", PricingRules{Combos: []ComboRule{{Name: "x"}}})
+	assertEqual(t, len(LoadPricingRules(b).Combos), 0, "")
+
+	dir, err := ioutil.TempDir("", "pricing")
+	assertEqual(t, err, nil, "")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.json")
+	ioutil.WriteFile(path, []byte(`{"Combos": [{"Name": "panino", "Types": ["panino"], "Price": 4}]}`), 0644)
+	os.Setenv("PRICING_RULES_FILE", path)
+	defer os.Unsetenv("PRICING_RULES_FILE")
+	assertEqual(t, LoadPricingRules(b).Combos[0].Price.String(), "4", "")
+}
@@ -0,0 +1,259 @@
+package tinabot
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+const ratingConversation = "rating"
+
+// ratingTTL is how long the bot waits for the ratings of the lunch
+const ratingTTL = 6 * time.Hour
+
+// ratingComments is the number of recent comments shown by the "voti" command
+const ratingComments = 5
+
+// Rating is the score, from 1 to 5, given by a user to a dish eaten on a day
+type Rating struct {
+	Date    string // 2006-01-02
+	User    User
+	Score   int
+	Comment string `json:",omitempty"`
+}
+
+// DishRatings holds the ratings of a dish, Name is the menu name it was last rated with
+type DishRatings struct {
+	Name    string
+	Ratings []Rating
+}
+
+// Average returns the average score of the dish
+func (d *DishRatings) Average() float64 {
+	if len(d.Ratings) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, r := range d.Ratings {
+		sum += r.Score
+	}
+	return float64(sum) / float64(len(d.Ratings))
+}
+
+// String returns the average score and the most recent comments
+func (d *DishRatings) String() string {
+	r := []string{fmt.Sprintf("*%s*: %.1f su %d voti", d.Name, d.Average(), len(d.Ratings))}
+
+	n := 0
+	for i := len(d.Ratings) - 1; i >= 0 && n < ratingComments; i-- {
+		rt := d.Ratings[i]
+		if rt.Comment == "" {
+			continue
+		}
+		date, _ := time.Parse("2006-01-02", rt.Date)
+		r = append(r, fmt.Sprintf("%s %s (%d): %s", date.Format("02/01"), rt.User.Name, rt.Score, rt.Comment))
+		n++
+	}
+	return strings.Join(r, "\n")
+}
+
+// canonicalDish returns the name under which the ratings of a dish are stored, so that the same
+// dish on different menus gets the same ratings
+func canonicalDish(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// loadRatings returns the ratings by canonical dish name
+func loadRatings(brain DataStore) map[string]DishRatings {
+	ratings := make(map[string]DishRatings)
+	brain.Get("ratings", &ratings)
+	return ratings
+}
+
+// addRating atomically records the rating of the dish, replacing the previous one of the same
+// user on the same day
+func addRating(brain DataStore, dish string, rating Rating) error {
+	var ratings map[string]DishRatings
+	return brain.Update("ratings", &ratings, func() error {
+		if ratings == nil {
+			ratings = make(map[string]DishRatings)
+		}
+		key := canonicalDish(dish)
+		d := ratings[key]
+		d.Name = dish
+
+		var r []Rating
+		for _, old := range d.Ratings {
+			if old.Date != rating.Date || old.User.ID != rating.User.ID {
+				r = append(r, old)
+			}
+		}
+		d.Ratings = append(r, rating)
+		sort.SliceStable(d.Ratings, func(i, j int) bool {
+			return d.Ratings[i].Date < d.Ratings[j].Date
+		})
+		ratings[key] = d
+		return nil
+	})
+}
+
+// findRatings returns the ratings of the dishes matching the name: the dish with the same canonical
+// name, otherwise the ones containing it
+func findRatings(ratings map[string]DishRatings, name string) []DishRatings {
+	key := canonicalDish(name)
+	if d, ok := ratings[key]; ok {
+		return []DishRatings{d}
+	}
+
+	var r []DishRatings
+	for k, d := range ratings {
+		if strings.Contains(k, key) {
+			r = append(r, d)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
+
+// bestRated returns the n dishes with the best average score
+func bestRated(ratings map[string]DishRatings, n int) []DishRatings {
+	var r []DishRatings
+	for _, d := range ratings {
+		if len(d.Ratings) > 0 {
+			r = append(r, d)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Average() != r[j].Average() {
+			return r[i].Average() > r[j].Average()
+		}
+		return r[i].Name < r[j].Name
+	})
+	if len(r) > n {
+		r = r[:n]
+	}
+	return r
+}
+
+// OrderedDishes returns the dishes ordered by the user, without duplicates
+func OrderedDishes(choices UserChoiceArray) []string {
+	var r []string
+	for _, c := range choices {
+		for _, d := range c.Dishes {
+			if !containsString(r, d.Content) {
+				r = append(r, d.Content)
+			}
+		}
+	}
+	return r
+}
+
+// AskRating asks the user to rate the dish number step of the lunch, the dishes are separated by
+// newlines. It returns false if there are no more dishes to rate.
+func AskRating(bot *slackbot.Bot, channel string, user User, date, dishes string, step int) bool {
+	list := strings.Split(dishes, "\n")
+	if step >= len(list) {
+		return false
+	}
+
+	intro := ""
+	if step == 0 {
+		intro = fmt.Sprintf("Ciao %s, com'era il pranzo? ", user.Name)
+	}
+	bot.Ask(channel, user.ID, fmt.Sprintf("%sChe voto dai a *%s*, da 1 a 5? Puoi aggiungere un commento dopo il voto (es. `4 un po' salato`), rispondi `0` per saltare o `stop` per smettere.",
+		intro, list[step]), slackbot.Conversation{
+		Action: ratingConversation,
+		Data: map[string]string{
+			"date":   date,
+			"dishes": dishes,
+			"step":   strconv.Itoa(step),
+		},
+		Expiry: time.Now().Add(ratingTTL),
+	})
+	return true
+}
+
+var ratingRe = regexp.MustCompile(`^([0-5])(?:\s+([\s\S]*))?$`)
+
+func (t *TinaBot) answerRating(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, conv *slackbot.Conversation, text string) bool {
+	text = strings.TrimSpace(sanitize(text))
+	if strings.ToLower(text) == "stop" {
+		bot.Message(msg.Channel, "Ok, grazie lo stesso!")
+		return true
+	}
+
+	m := ratingRe.FindStringSubmatch(text)
+	if m == nil {
+		return false
+	}
+
+	step, _ := strconv.Atoi(conv.Data["step"])
+	dishes := strings.Split(conv.Data["dishes"], "\n")
+	if step >= len(dishes) {
+		return true
+	}
+
+	if score, _ := strconv.Atoi(m[1]); score > 0 {
+		err := addRating(t.brain, dishes[step], Rating{
+			Date:    conv.Data["date"],
+			User:    User{user.Name, user.ID},
+			Score:   score,
+			Comment: strings.TrimSpace(m[2]),
+		})
+		if err != nil {
+			log.Println(err)
+			bot.Message(msg.Channel, "Non sono riuscito a salvare il voto, riprova: "+err.Error())
+			AskRating(bot, msg.Channel, User{user.Name, user.ID}, conv.Data["date"], conv.Data["dishes"], step)
+			return true
+		}
+	}
+
+	if !AskRating(bot, msg.Channel, User{user.Name, user.ID}, conv.Data["date"], conv.Data["dishes"], step+1) {
+		bot.Message(msg.Channel, "Grazie per i tuoi voti!")
+	}
+	return true
+}
+
+// Ratings shows the ratings of the dishes: "voti <piatto>" for a dish, "voti" for the best rated ones
+func (t *TinaBot) Ratings(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	ratings := loadRatings(t.brain)
+	name := strings.TrimSpace(sanitize(args[1]))
+
+	if name == "" {
+		best := bestRated(ratings, statsTop)
+		if len(best) == 0 {
+			bot.Message(msg.Channel, "Nessun piatto ha ancora ricevuto un voto")
+			return
+		}
+		var r []string
+		for _, d := range best {
+			r = append(r, fmt.Sprintf("%s: %.1f su %d voti", d.Name, d.Average(), len(d.Ratings)))
+		}
+		bot.Message(msg.Channel, "*I piatti più votati:*\n"+strings.Join(r, "\n"))
+		return
+	}
+
+	found := findRatings(ratings, name)
+	switch {
+	case len(found) == 0:
+		bot.Message(msg.Channel, fmt.Sprintf("Nessun voto per '%s'", name))
+	case len(found) == 1:
+		bot.Message(msg.Channel, found[0].String())
+	default:
+		var r []string
+		for _, d := range found {
+			r = append(r, fmt.Sprintf("%s: %.1f su %d voti", d.Name, d.Average(), len(d.Ratings)))
+		}
+		bot.Message(msg.Channel, fmt.Sprintf("Ho trovato più piatti per '%s':\n%s", name, strings.Join(r, "\n")))
+	}
+}
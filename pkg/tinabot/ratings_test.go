@@ -0,0 +1,53 @@
+package tinabot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestCanonicalDish(t *testing.T) {
+	assertEqual(t, canonicalDish("  Pasta  al Pomodoro "), "pasta al pomodoro", "")
+}
+
+func TestRatings(t *testing.T) {
+	b := brain.NewBrainMock()
+	bob := User{"bob", "U1"}
+	alice := User{"alice", "U2"}
+
+	addRating(b, "Pasta al pomodoro", Rating{Date: "2026-10-19", User: bob, Score: 2})
+	addRating(b, "Pasta al pomodoro", Rating{Date: "2026-10-19", User: alice, Score: 4, Comment: "buona"})
+	// the same user on the same day replaces the vote
+	addRating(b, "Pasta  al POMODORO", Rating{Date: "2026-10-19", User: bob, Score: 3, Comment: "scotta"})
+	addRating(b, "Pasta al pesto", Rating{Date: "2026-10-12", User: bob, Score: 5})
+
+	ratings := loadRatings(b)
+	assertEqual(t, len(ratings), 2, "")
+	d := ratings["pasta al pomodoro"]
+	assertEqual(t, len(d.Ratings), 2, "")
+	assertEqual(t, d.Average(), 3.5, "")
+	assertEqual(t, d.String(), "*Pasta  al POMODORO*: 3.5 su 2 voti\n19/10 bob (3): scotta\n19/10 alice (4): buona", "")
+
+	assertEqual(t, len(findRatings(ratings, "pasta al pesto")), 1, "")
+	assertEqual(t, len(findRatings(ratings, "pasta")), 2, "")
+	assertEqual(t, len(findRatings(ratings, "risotto")), 0, "")
+
+	best := bestRated(ratings, 1)
+	assertEqual(t, len(best), 1, "")
+	assertEqual(t, best[0].Name, "Pasta al pesto", "")
+}
+
+func TestOrderedDishes(t *testing.T) {
+	p := tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo}
+	s := tuttobene.MenuRow{Content: "secondo", Type: tuttobene.Secondo}
+	c := tuttobene.MenuRow{Content: "contorno", Type: tuttobene.Contorno}
+
+	choices := UserChoiceArray{
+		{Dishes: []tuttobene.MenuRow{p}},
+		{Dishes: []tuttobene.MenuRow{s, c}},
+		{Dishes: []tuttobene.MenuRow{p}},
+	}
+	assertEqual(t, strings.Join(OrderedDishes(choices), ","), "primo,secondo,contorno", "")
+}
@@ -1,7 +1,12 @@
 package tinabot
 
 import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/nlopes/slack"
@@ -9,58 +14,249 @@ import (
 	"github.com/develersrl/lunches/pkg/slackbot"
 )
 
-func formatReminder(mask int) string {
-	weekNames := []string{
-		"domenica",
-		"lunedì",
-		"martedì",
-		"mercoledì",
-		"giovedì",
-		"venerdì",
-		"sabato",
+var weekNames = []string{
+	"domenica",
+	"lunedì",
+	"martedì",
+	"mercoledì",
+	"giovedì",
+	"venerdì",
+	"sabato",
+}
+
+// formatWeekMask returns the human readable list of days set in mask
+func formatWeekMask(mask int) string {
+	if mask&0x7f == 0x7f {
+		return "tutti i giorni"
+	}
+
+	var days []string
+	for i := uint(0); i < 7; i++ {
+		if ((1 << i) & mask) != 0 {
+			days = append(days, weekNames[i])
+		}
 	}
+	return strings.Join(days, ", ")
+}
 
+func formatReminder(mask int, at string) string {
 	if mask == 0 {
 		return "Reminder disattivato"
 	}
 
-	reply := "Reminder attivo "
-	if mask == 0xff {
-		reply += "tutti i giorni"
-	} else {
-		var days []string
-		for i := uint(0); i < 7; i++ {
-			if ((1 << i) & mask) != 0 {
-				days = append(days, weekNames[i])
+	return "Reminder attivo " + formatWeekMask(mask) + " alle " + at
+}
+
+// defaultRemindTime is the time of the reminders of the users who did not choose one
+const defaultRemindTime = "11:50"
+
+// remindGrace is how late a reminder can be sent, after that it is skipped for the day
+const remindGrace = time.Hour
+
+// remindState keeps track of the reminders sent, so that each one is sent at most once
+type remindState struct {
+	Sent   map[string]string    // date of the last daily reminder, by user ID
+	Snooze map[string]time.Time // one-shot reminders, by user ID
+}
+
+var remindTimeRe = regexp.MustCompile(`^(\d{1,2})[:.](\d{2})$`)
+
+// parseRemindTime parses a time of the day like "11:30" or "11.30"
+func parseRemindTime(s string) (string, bool) {
+	m := remindTimeRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return "", false
+	}
+	h, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	if h > 23 || min > 59 {
+		return "", false
+	}
+	return fmt.Sprintf("%02d:%02d", h, min), true
+}
+
+// remindTime returns the time of the reminder of the user
+func remindTime(times map[string]string, userID string) string {
+	if at, ok := times[userID]; ok {
+		return at
+	}
+	return defaultRemindTime
+}
+
+// remindAt returns the time of the day of now given as "15:04"
+func remindAt(at string, now time.Time) time.Time {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		t, _ = time.Parse("15:04", defaultRemindTime)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+}
+
+// DueReminders returns the IDs of the users to remind now, either because it's their daily reminder
+// time, unless they are absent, or because they asked to be reminded later. The reminders are marked
+// as sent, so that each one is returned only once.
+func DueReminders(brain DataStore, now time.Time) ([]string, error) {
+	var masks map[string]int
+	brain.Get("remind", &masks)
+	var times map[string]string
+	brain.Get("remind_times", &times)
+
+	absences := LoadAbsences(brain)
+
+	today := now.Format("2006-01-02")
+	weekmask := 1 << uint(now.Weekday())
+
+	var due []string
+	var state remindState
+	err := brain.Update("remind_state", &state, func() error {
+		due = nil
+		if state.Sent == nil {
+			state.Sent = make(map[string]string)
+		}
+
+		for id, mask := range masks {
+			if mask&weekmask == 0 || state.Sent[id] == today {
+				continue
+			}
+			if a, ok := absences[id]; ok && a.Absent(now) {
+				continue
+			}
+			at := remindAt(remindTime(times, id), now)
+			if now.Before(at) || now.Sub(at) > remindGrace {
+				continue
 			}
+			state.Sent[id] = today
+			due = append(due, id)
+		}
+
+		for id, at := range state.Snooze {
+			if now.Before(at) {
+				continue
+			}
+			delete(state.Snooze, id)
+			if !containsString(due, id) {
+				due = append(due, id)
+			}
+		}
+		return nil
+	})
+	return due, err
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
 		}
-		reply += strings.Join(days, ", ")
 	}
-	return reply
+	return false
 }
 
-func (t *TinaBot) Remind(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
-	weekMask := map[string]int{
-		"off": 0,
-		"dis": 0,
-		"fal": 0,
-		"0":   0,
-		"on":  0xff,
-		"ena": 0xff,
-		"tru": 0xff,
-		"1":   0xff,
-		"sem": 0xff,
-		"tut": 0xff,
-		"all": 0xff,
-
-		"dom": 1 << 0,
-		"lun": 1 << 1,
-		"mar": 1 << 2,
-		"mer": 1 << 3,
-		"gio": 1 << 4,
-		"ven": 1 << 5,
-		"sab": 1 << 6,
+// snoozeReminder sets a one-shot reminder for the user
+func snoozeReminder(brain DataStore, userID string, at time.Time) error {
+	var state remindState
+	return brain.Update("remind_state", &state, func() error {
+		if state.Snooze == nil {
+			state.Snooze = make(map[string]time.Time)
+		}
+		state.Snooze[userID] = at
+		return nil
+	})
+}
+
+// setReminder atomically sets the days of the reminder of the user and, if at is not empty, its time.
+// If onlyTime is set the days are kept, or the reminder is enabled every day if it was not set.
+// It returns the days set.
+func setReminder(brain DataStore, userID string, mask int, onlyTime bool, at string) (int, error) {
+	var remind map[string]int
+	err := brain.Update("remind", &remind, func() error {
+		if remind == nil {
+			remind = make(map[string]int)
+		}
+		m := mask
+		if onlyTime {
+			m = 0xff
+			if val, ok := remind[userID]; ok {
+				m = val
+			}
+		}
+
+		if m == 0 {
+			delete(remind, userID)
+		} else {
+			remind[userID] = m
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	mask = remind[userID]
+
+	if at == "" {
+		return mask, nil
+	}
+	var times map[string]string
+	return mask, brain.Update("remind_times", &times, func() error {
+		if times == nil {
+			times = make(map[string]string)
+		}
+		times[userID] = at
+		return nil
+	})
+}
+
+// ReminderText returns the reminder message for the user
+func ReminderText(name, menu string) string {
+	return fmt.Sprintf("Ciao %s, scusa il disturbo. Vedo che non hai ancora ordinato il pranzo e mi hai chiesto di ricordartelo. Ecco il menù di oggi:\n%s\n"+
+		"Se vuoi che te lo ricordi più tardi scrivimi `ricordami tra 15 minuti`", name, menu)
+}
+
+var weekMask = map[string]int{
+	"off": 0,
+	"dis": 0,
+	"fal": 0,
+	"0":   0,
+	"on":  0xff,
+	"ena": 0xff,
+	"tru": 0xff,
+	"1":   0xff,
+	"sem": 0xff,
+	"tut": 0xff,
+	"all": 0xff,
+
+	"dom": 1 << 0,
+	"lun": 1 << 1,
+	"mar": 1 << 2,
+	"mer": 1 << 3,
+	"gio": 1 << 4,
+	"ven": 1 << 5,
+	"sab": 1 << 6,
+}
+
+// parseWeekMask parses a comma separated list of week days (or on/off keywords)
+// and returns the corresponding bitmask, with bit 0 being sunday.
+// The second return value is false if no valid day was found.
+func parseWeekMask(s string) (int, bool) {
+	mask := 0
+	found := false
+	for _, d := range strings.Split(strings.ToLower(s), ",") {
+		d = strings.TrimSpace(d)
+		if len(d) > 3 {
+			d = d[:3]
+		}
+
+		if m, ok := weekMask[d]; ok {
+			mask |= m
+			found = true
+		}
 	}
+	return mask, found
+}
+
+func (t *TinaBot) Remind(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	var times map[string]string
+	t.brain.Get("remind_times", &times)
 
 	if args[1] == "" {
 		var remind map[string]int
@@ -69,43 +265,78 @@ func (t *TinaBot) Remind(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.Us
 			bot.Message(msg.Channel, "Non c'è nessun reminder impostato")
 		} else {
 			if val, ok := remind[user.ID]; ok {
-				bot.Message(msg.Channel, formatReminder(val))
+				bot.Message(msg.Channel, formatReminder(val, remindTime(times, user.ID)))
 			} else {
 				bot.Message(msg.Channel, "Non c'è nessun reminder impostato")
 			}
 		}
 
 	} else {
-		arg := strings.ToLower(args[1])
-		mask := 0
-
-		cmdFound := false
-		for _, d := range strings.Split(arg, ",") {
-			d = strings.TrimSpace(d)
-			if len(d) > 3 {
-				d = d[:3]
+		days := strings.TrimSpace(args[1])
+		at, timeFound := "", false
+		if i := strings.LastIndexAny(days, " ,"); i >= 0 {
+			at, timeFound = parseRemindTime(days[i+1:])
+			if timeFound {
+				days = days[:i]
 			}
-
-			if m, ok := weekMask[d]; ok {
-				mask |= m
-				cmdFound = true
+		} else {
+			at, timeFound = parseRemindTime(days)
+			if timeFound {
+				days = ""
 			}
 		}
 
-		if !cmdFound {
+		mask, cmdFound := parseWeekMask(days)
+		if !cmdFound && (!timeFound || strings.TrimSpace(days) != "") {
 			bot.Message(msg.Channel, "Mi spiace, ma non ho capito cosa mi stai chiedendo di ricordare")
 			return
 		}
 
-		remind := make(map[string]int)
-		t.brain.Get("remind", &remind)
-		if mask == 0 {
-			delete(remind, user.ID)
-		} else {
-			remind[user.ID] = mask
+		mask, err := setReminder(t.brain, user.ID, mask, !cmdFound, at)
+		if err != nil {
+			log.Println(err)
+			bot.Message(msg.Channel, "Non sono riuscito a salvare il reminder, riprova: "+err.Error())
+			return
+		}
+
+		if !timeFound {
+			at = remindTime(times, user.ID)
 		}
-		t.brain.Set("remind", remind)
+		bot.Message(msg.Channel, formatReminder(mask, at))
+	}
+}
+
+var snoozeRe = regexp.MustCompile(`^(?i)tra\s+(\d+)\s+(minut[oi]|or[ae])$`)
+
+// Snooze sends the reminder again after the given time, e.g. "ricordami tra 15 minuti"
+func (t *TinaBot) Snooze(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	m := snoozeRe.FindStringSubmatch(strings.TrimSpace(args[1]))
+	if m == nil {
+		bot.Message(msg.Channel, "Usa `ricordami tra <n> minuti` oppure `ricordami tra <n> ore`")
+		return
+	}
+
+	n, _ := strconv.Atoi(m[1])
+	d := time.Duration(n) * time.Minute
+	if strings.HasPrefix(strings.ToLower(m[2]), "or") {
+		d = time.Duration(n) * time.Hour
+	}
+	if d <= 0 || d > 12*time.Hour {
+		bot.Message(msg.Channel, "Posso ricordartelo tra un minuto e 12 ore al massimo")
+		return
+	}
+
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
 
-		bot.Message(msg.Channel, formatReminder(mask))
+	at := time.Now().In(loc).Add(d)
+	if err := snoozeReminder(t.brain, user.ID, at); err != nil {
+		log.Println(err)
+		bot.Message(msg.Channel, "Non sono riuscito a salvare il reminder, riprova: "+err.Error())
+		return
 	}
+	bot.Message(msg.Channel, fmt.Sprintf("Ok, te lo ricordo alle %s", at.Format("15:04")))
 }
@@ -0,0 +1,104 @@
+package tinabot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+)
+
+func TestParseRemindTime(t *testing.T) {
+	at, ok := parseRemindTime("11:30")
+	assertEqual(t, ok, true, "")
+	assertEqual(t, at, "11:30", "")
+	at, ok = parseRemindTime("9.05")
+	assertEqual(t, ok, true, "")
+	assertEqual(t, at, "09:05", "")
+	_, ok = parseRemindTime("25:00")
+	assertEqual(t, ok, false, "")
+	_, ok = parseRemindTime("mer")
+	assertEqual(t, ok, false, "")
+
+	assertEqual(t, formatReminder(1<<1|1<<3, "11:30"), "Reminder attivo lunedì, mercoledì alle 11:30", "")
+}
+
+func TestDueReminders(t *testing.T) {
+	b := brain.NewBrainMock()
+	b.Set("remind", map[string]int{"U1": 0xff, "U2": 1 << 1, "U3": 0xff})
+	b.Set("remind_times", map[string]string{"U1": "11:30"})
+
+	// monday
+	day := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time {
+		return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute)
+	}
+
+	due, err := DueReminders(b, at(11, 20))
+	assertEqual(t, err, nil, "")
+	assertEqual(t, len(due), 0, "")
+
+	due, _ = DueReminders(b, at(11, 35))
+	assertEqual(t, strings.Join(due, ","), "U1", "")
+
+	// each reminder is sent only once
+	due, _ = DueReminders(b, at(11, 40))
+	assertEqual(t, len(due), 0, "")
+
+	due, _ = DueReminders(b, at(11, 50))
+	sort.Strings(due)
+	assertEqual(t, strings.Join(due, ","), "U2,U3", "")
+
+	snoozeReminder(b, "U1", at(12, 5))
+	due, _ = DueReminders(b, at(12, 0))
+	assertEqual(t, len(due), 0, "")
+	due, _ = DueReminders(b, at(12, 10))
+	assertEqual(t, strings.Join(due, ","), "U1", "")
+	due, _ = DueReminders(b, at(12, 15))
+	assertEqual(t, len(due), 0, "")
+
+	// U2 only on mondays, and reminders too late are skipped
+	due, _ = DueReminders(b, day.AddDate(0, 0, 1).Add(11*time.Hour+40*time.Minute))
+	assertEqual(t, strings.Join(due, ","), "U1", "")
+	due, _ = DueReminders(b, day.AddDate(0, 0, 1).Add(14*time.Hour))
+	assertEqual(t, len(due), 0, "")
+}
+
+func TestSetReminderConcurrent(t *testing.T) {
+	b := brain.NewBrainMock()
+
+	const n = 40
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := setReminder(b, fmt.Sprintf("U%d", i), 1<<1, false, "12:00")
+			assertEqual(t, err, nil, "")
+		}(i)
+	}
+	wg.Wait()
+
+	var remind map[string]int
+	b.Get("remind", &remind)
+	assertEqual(t, len(remind), n, "")
+	var times map[string]string
+	b.Get("remind_times", &times)
+	assertEqual(t, len(times), n, "")
+
+	// only the time keeps the days
+	mask, _ := setReminder(b, "U0", 0, true, "11:00")
+	assertEqual(t, mask, 1<<1, "")
+	mask, _ = setReminder(b, "U99", 0, true, "11:00")
+	assertEqual(t, mask, 0xff, "")
+
+	mask, _ = setReminder(b, "U0", 0, false, "")
+	assertEqual(t, mask, 0, "")
+	remind = nil
+	b.Get("remind", &remind)
+	_, ok := remind["U0"]
+	assertEqual(t, ok, false, "")
+}
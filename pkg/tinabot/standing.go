@@ -0,0 +1,223 @@
+package tinabot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+// StandingOrder is an order that is automatically placed for the user on the given week days
+type StandingOrder struct {
+	User     User
+	WeekMask int
+	Dish     string
+}
+
+// standingResult is the outcome of placing a standing order
+type standingResult struct {
+	Standing StandingOrder
+	Dishes   []string
+	Report   string
+	Err      error
+}
+
+const standingCallback = "standing_order"
+
+func loadStandingOrders(brain DataStore) map[string][]StandingOrder {
+	standing := make(map[string][]StandingOrder)
+	brain.Get("standing", &standing)
+	return standing
+}
+
+// setStandingOrder sets the dish for the days in mask, removing those days from the other
+// standing orders of the user. An empty dish just removes the days.
+func setStandingOrder(standing map[string][]StandingOrder, user User, mask int, dish string) {
+	var list []StandingOrder
+	for _, s := range standing[user.ID] {
+		s.WeekMask &^= mask
+		if s.WeekMask&0x7f != 0 {
+			list = append(list, s)
+		}
+	}
+
+	if dish != "" && mask != 0 {
+		list = append(list, StandingOrder{User: user, WeekMask: mask, Dish: dish})
+	}
+
+	if len(list) == 0 {
+		delete(standing, user.ID)
+	} else {
+		standing[user.ID] = list
+	}
+}
+
+// applyStandingOrders places in the order the standing orders matching the menu week day,
+// skipping the users that already ordered something.
+func applyStandingOrders(brain DataStore, pricing *PricingRules, order *Order, standing map[string][]StandingOrder, menu tuttobene.Menu) []standingResult {
+	var results []standingResult
+	weekmask := 1 << uint(menu.Date.Weekday())
+
+	for _, list := range standing {
+		for _, s := range list {
+			if s.WeekMask&weekmask == 0 {
+				continue
+			}
+
+			if _, ok := order.Users[s.User]; ok {
+				log.Printf("Standing order skipped, %s has already ordered\n", s.User.Name)
+				continue
+			}
+
+			choice, report, err := parseOrder(menu, s.Dish)
+			res := standingResult{Standing: s, Report: report, Err: err}
+			if err == nil {
+				res.Dishes = setChoices(brain, pricing, order, s.User, s.User, choice)
+			}
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+// PlaceStandingOrders places the standing orders for the day of the menu and informs the users.
+// It must be called every time a new menu is set.
+func (t *TinaBot) PlaceStandingOrders(menu tuttobene.Menu) {
+	if !menu.IsUpdated() {
+		return
+	}
+
+	standing := loadStandingOrders(t.brain)
+	if len(standing) == 0 {
+		return
+	}
+
+	var results []standingResult
+	var date string
+	err := UpdateOrder(t.brain, func(order *Order) error {
+		results = applyStandingOrders(t.brain, t.pricing, order, standing, menu)
+		date = order.Timestamp.Format("2006-01-02")
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, r := range results {
+		_, _, ch, err := t.bot.Client.OpenIMChannel(r.Standing.User.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if r.Err != nil {
+			t.bot.Message(ch, fmt.Sprintf("Ciao %s, oggi avresti l'ordine fisso '%s' ma non sono riuscito ad ordinarlo:\n%s%s",
+				r.Standing.User.Name, r.Standing.Dish, r.Report, r.Err.Error()))
+			continue
+		}
+
+		log.Printf("Standing order placed for %s\n", r.Standing.User.Name)
+		t.bot.MessageWithButtons(ch, fmt.Sprintf("Ciao %s, come da tuo ordine fisso ho ordinato per te:\n%s",
+			r.Standing.User.Name, strings.Join(r.Dishes, "\n")),
+			standingCallback,
+			slack.AttachmentAction{
+				Name:  "cancel",
+				Text:  "Annulla ordine",
+				Style: "danger",
+				Value: date,
+			})
+	}
+}
+
+func (t *TinaBot) cancelStandingOrder(bot *slackbot.Bot, cb *slack.InteractionCallback) {
+	errExpired := errors.New("Questo ordine non è più valido, non c'è nulla da annullare")
+	errNoOrder := errors.New("Non hai nessun ordine da annullare")
+
+	var me User
+	var old string
+	var entry undoEntry
+	err := UpdateOrder(t.brain, func(order *Order) error {
+		if len(cb.Actions) == 0 || cb.Actions[0].Value != order.Timestamp.Format("2006-01-02") {
+			return errExpired
+		}
+
+		for u := range order.Users {
+			if u.ID == cb.User.ID {
+				me = u
+				entry = newUndoEntry(order, "annulla ordine fisso", u)
+				old = order.ClearUser(u)
+				return nil
+			}
+		}
+		return errNoOrder
+	})
+	if err != nil {
+		bot.Message(cb.Channel.ID, err.Error())
+		return
+	}
+
+	pushUndo(t.brain, me, entry)
+	bot.Message(cb.Channel.ID, "Ok, ho cancellato il tuo ordine:\n"+old)
+}
+
+// Standing handles the standing orders of the user: "ogni <giorni>: <ordine>"
+func (t *TinaBot) Standing(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	me := User{user.Name, user.ID}
+	standing := loadStandingOrders(t.brain)
+	arg := strings.TrimSpace(sanitize(args[1]))
+
+	if arg == "" {
+		list := standing[user.ID]
+		if len(list) == 0 {
+			bot.Message(msg.Channel, "Non hai nessun ordine fisso impostato")
+			return
+		}
+
+		reply := "Ecco i tuoi ordini fissi:\n"
+		for _, s := range list {
+			reply += fmt.Sprintf("%s: %s\n", formatWeekMask(s.WeekMask), s.Dish)
+		}
+		bot.Message(msg.Channel, reply)
+		return
+	}
+
+	l := strings.SplitN(arg, ":", 2)
+	mask, found := parseWeekMask(l[0])
+	if !found {
+		bot.Message(msg.Channel, "Mi spiace, ma non ho capito in quali giorni vuoi ordinare.\nUsa ad esempio `ogni ven: pasta al pomodoro`")
+		return
+	}
+
+	if len(l) < 2 && mask != 0 {
+		bot.Message(msg.Channel, "Cosa devo ordinare? Usa ad esempio `ogni ven: pasta al pomodoro`")
+		return
+	}
+
+	dish := ""
+	if len(l) > 1 {
+		dish = strings.TrimSpace(l[1])
+	}
+	if strings.ToLower(dish) == "niente" {
+		dish = ""
+	}
+
+	if mask == 0 {
+		// "ogni off" removes all the standing orders of the user
+		setStandingOrder(standing, me, 0x7f, "")
+	} else {
+		setStandingOrder(standing, me, mask, dish)
+	}
+	t.brain.Set("standing", standing)
+
+	if dish == "" || mask == 0 {
+		bot.Message(msg.Channel, "Ok, ordine fisso rimosso")
+	} else {
+		bot.Message(msg.Channel, fmt.Sprintf("Ok, %s ordinerò per te: %s", formatWeekMask(mask), dish))
+	}
+}
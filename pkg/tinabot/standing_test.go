@@ -0,0 +1,72 @@
+package tinabot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestParseWeekMask(t *testing.T) {
+	tests := map[string]int{
+		"lun":            1 << 1,
+		"lun,mer":        1<<1 | 1<<3,
+		"Venerdì":        1 << 5,
+		"lunedì, sabato": 1<<1 | 1<<6,
+		"on":             0xff,
+		"off":            0,
+	}
+
+	for s, m := range tests {
+		mask, found := parseWeekMask(s)
+		assertEqual(t, found, true, s)
+		assertEqual(t, mask, m, s)
+	}
+
+	_, found := parseWeekMask("boh")
+	assertEqual(t, found, false, "")
+}
+
+func TestStandingOrders(t *testing.T) {
+	u1 := User{"test", "123"}
+	u2 := User{"test2", "456"}
+
+	standing := make(map[string][]StandingOrder)
+	setStandingOrder(standing, u1, 1<<5, "pomodoro")
+	setStandingOrder(standing, u1, 1<<1|1<<3, "lasagne")
+	setStandingOrder(standing, u2, 1<<5, "cotoletta")
+	assertEqual(t, len(standing[u1.ID]), 2, "")
+
+	// overriding a day removes it from the previous standing order
+	setStandingOrder(standing, u1, 1<<3, "pesto")
+	assertEqual(t, len(standing[u1.ID]), 3, "")
+	assertEqual(t, standing[u1.ID][1].WeekMask, 1<<1, "")
+
+	setStandingOrder(standing, u1, 1<<1|1<<3, "")
+	assertEqual(t, len(standing[u1.ID]), 1, "")
+
+	menu := tuttobene.Menu{
+		// 2019-03-01 is a friday
+		Date: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC),
+		Rows: []tuttobene.MenuRow{
+			{Content: "Pasta al pomodoro", Type: tuttobene.Primo},
+			{Content: "Lasagne", Type: tuttobene.Primo},
+		},
+	}
+
+	order := NewOrder()
+	res := applyStandingOrders(brain.NewBrainMock(), &PricingRules{}, order, standing, menu)
+	assertEqual(t, len(res), 2, "")
+	assertEqual(t, order.String(), "1 Pasta al pomodoro [test]", "")
+
+	// users that already ordered are skipped
+	setStandingOrder(standing, u2, 1<<5, "lasagne")
+	res = applyStandingOrders(brain.NewBrainMock(), &PricingRules{}, order, standing, menu)
+	assertEqual(t, len(res), 1, "")
+	assertEqual(t, order.String(), "1 Lasagne [test2]\n1 Pasta al pomodoro [test]", "")
+
+	menu.Date = menu.Date.Add(24 * time.Hour)
+	res = applyStandingOrders(brain.NewBrainMock(), &PricingRules{}, NewOrder(), standing, menu)
+	assertEqual(t, len(res), 0, "")
+}
@@ -0,0 +1,213 @@
+package tinabot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// statsTop is the number of entries shown in the rankings
+const statsTop = 5
+
+var markNames = map[string]string{
+	firstDish:   "primi",
+	secondDish:  "secondi",
+	dessertDish: "frutta e dolci",
+}
+
+// lunchStats holds the statistics computed from the archived orders
+type lunchStats struct {
+	Lunches  int
+	Guests   int
+	PerDay   map[string]int // lunches for each day ("2006-01-02")
+	Dishes   map[string]int // ordered dishes by menu name
+	Composed map[string]int // ordered composed plates
+	Marks    map[string]int // ordered dishes by category (primi, secondi...)
+	Users    map[User]int   // lunches for each user
+}
+
+func computeStats(orders []Order) lunchStats {
+	s := lunchStats{
+		PerDay:   make(map[string]int),
+		Dishes:   make(map[string]int),
+		Composed: make(map[string]int),
+		Marks:    make(map[string]int),
+		Users:    make(map[User]int),
+	}
+
+	for _, o := range orders {
+		date := o.Timestamp.Format("2006-01-02")
+		for u, choices := range o.Users {
+			s.Lunches++
+			s.PerDay[date]++
+			s.Users[u]++
+			if isGuest(u) {
+				s.Guests++
+			}
+
+			for _, c := range choices {
+				n := c.Count()
+				for _, d := range c.Dishes {
+					s.Dishes[d.Content] += n
+				}
+				if c.Customized() {
+					s.Composed[c.String()] += n
+				}
+				if m := c.mark(); m != noDish {
+					s.Marks[m] += n
+				}
+			}
+		}
+	}
+	return s
+}
+
+// ranking returns the first n keys of the map, sorted by decreasing count and then by name
+func ranking(counts map[string]int, n int) []string {
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// format returns the statistics report; if only is set, the per user counts are limited to her
+func (s lunchStats) format(only *User) string {
+	if s.Lunches == 0 {
+		return "Nessun pranzo ordinato nel periodo"
+	}
+
+	var r []string
+	r = append(r, fmt.Sprintf("Pranzi: %d in %d giorni (media %.1f al giorno)", s.Lunches, len(s.PerDay), float64(s.Lunches)/float64(len(s.PerDay))))
+	if s.Guests > 0 {
+		r = append(r, fmt.Sprintf("Ospiti: %d", s.Guests))
+	}
+
+	var days []string
+	for d := range s.PerDay {
+		days = append(days, d)
+	}
+	sort.Strings(days)
+
+	if len(days) <= 7 {
+		r = append(r, "*Pranzi per giorno:*")
+		for _, d := range days {
+			day, _ := time.Parse("2006-01-02", d)
+			r = append(r, fmt.Sprintf("%s %s: %d", weekNames[day.Weekday()], day.Format("02/01"), s.PerDay[d]))
+		}
+	} else {
+		r = append(r, "*Pranzi per settimana:*")
+		weeks := make(map[string]int)
+		var mondays []string
+		for _, d := range days {
+			day, _ := time.Parse("2006-01-02", d)
+			monday := day.AddDate(0, 0, -(int(day.Weekday())+6)%7).Format("2006-01-02")
+			if _, ok := weeks[monday]; !ok {
+				mondays = append(mondays, monday)
+			}
+			weeks[monday] += s.PerDay[d]
+		}
+		for _, m := range mondays {
+			day, _ := time.Parse("2006-01-02", m)
+			r = append(r, fmt.Sprintf("settimana del %s: %d", day.Format("02/01"), weeks[m]))
+		}
+	}
+
+	r = append(r, "*Piatti più ordinati:*")
+	for _, d := range ranking(s.Dishes, statsTop) {
+		r = append(r, fmt.Sprintf("%d %s", s.Dishes[d], d))
+	}
+
+	total := 0
+	for _, n := range s.Marks {
+		total += n
+	}
+	if total > 0 {
+		var shares []string
+		for _, m := range []string{firstDish, secondDish, dessertDish} {
+			shares = append(shares, fmt.Sprintf("%s %d%% (%d)", markNames[m], s.Marks[m]*100/total, s.Marks[m]))
+		}
+		r = append(r, "*Primi e secondi:*", strings.Join(shares, ", "))
+	}
+
+	if len(s.Composed) > 0 {
+		r = append(r, "*Piatti composti più ordinati:*")
+		for _, d := range ranking(s.Composed, statsTop) {
+			r = append(r, fmt.Sprintf("%d %s", s.Composed[d], d))
+		}
+	}
+
+	if only != nil {
+		r = append(r, fmt.Sprintf("*I tuoi pranzi:* %d", s.Users[*only]))
+	} else {
+		users := make(map[string]int)
+		for u, n := range s.Users {
+			users[u.Name] += n
+		}
+		r = append(r, "*Pranzi per utente:*")
+		for _, u := range ranking(users, 0) {
+			r = append(r, fmt.Sprintf("%s: %d", u, users[u]))
+		}
+	}
+
+	return strings.Join(r, "\n")
+}
+
+// statsPeriod returns the first and last day of the period: "settimana", "mese" (the default),
+// "anno" or a month as "mm/aaaa"
+func statsPeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch strings.ToLower(strings.TrimSpace(period)) {
+	case "settimana":
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7), today, nil
+	case "", "mese":
+		return today.AddDate(0, 0, 1-today.Day()), today, nil
+	case "anno":
+		return time.Date(today.Year(), 1, 1, 0, 0, 0, 0, now.Location()), today, nil
+	}
+
+	month, err := time.ParseInLocation("01/2006", strings.TrimSpace(period), now.Location())
+	if err != nil {
+		return now, now, fmt.Errorf("Periodo '%s' non valido, usa `settimana`, `mese`, `anno` oppure un mese come `mm/aaaa`", period)
+	}
+	return month, month.AddDate(0, 1, -1), nil
+}
+
+// Stats shows the statistics of the lunches in the period
+func (t *TinaBot) Stats(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Println("LoadLocation error: ", err)
+		return
+	}
+
+	from, to, err := statsPeriod(args[1], time.Now().In(loc))
+	if err != nil {
+		bot.Message(msg.Channel, err.Error())
+		return
+	}
+
+	var only *User
+	if strings.HasPrefix(msg.Channel, "D") {
+		only = &User{user.Name, user.ID}
+	}
+
+	stats := computeStats(loadArchive(t.brain, from, to))
+	bot.Message(msg.Channel, fmt.Sprintf("Statistiche dal %s al %s:\n%s", from.Format("02/01/2006"), to.Format("02/01/2006"), stats.format(only)))
+}
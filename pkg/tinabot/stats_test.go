@@ -0,0 +1,130 @@
+package tinabot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestArchive(t *testing.T) {
+	b := brain.NewBrainMock()
+	var pasta UserChoice
+	pasta.Add(tuttobene.MenuRow{Content: "Pasta", Type: tuttobene.Primo})
+
+	for _, d := range []time.Time{
+		time.Date(2026, 9, 30, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 2, 12, 0, 0, 0, time.UTC),
+	} {
+		order := NewOrder()
+		order.Timestamp = d
+		order.Set(User{"me", "1"}, []UserChoice{pasta})
+		assertEqual(t, archiveOrder(b, order), nil, "")
+	}
+
+	orders := loadArchive(b, time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	assertEqual(t, len(orders), 2, "")
+	assertEqual(t, orders[0].Timestamp.Day(), 30, "")
+	assertEqual(t, orders[1].Timestamp.Day(), 1, "")
+
+	// an empty order removes the day from the archive
+	order := NewOrder()
+	order.Timestamp = time.Date(2026, 10, 2, 12, 0, 0, 0, time.UTC)
+	archiveOrder(b, order)
+	orders = loadArchive(b, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC))
+	assertEqual(t, len(orders), 1, "")
+}
+
+func TestArchiveConcurrent(t *testing.T) {
+	b := brain.NewBrainMock()
+	var pasta UserChoice
+	pasta.Add(tuttobene.MenuRow{Content: "Pasta", Type: tuttobene.Primo})
+
+	const n = 40
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := User{fmt.Sprintf("user%d", i), fmt.Sprintf("%d", i)}
+			err := UpdateOrder(b, func(order *Order) error {
+				order.Set(u, []UserChoice{pasta})
+				return nil
+			})
+			assertEqual(t, err, nil, "")
+		}(i)
+	}
+	wg.Wait()
+
+	now := NewOrder().Timestamp
+	orders := loadArchive(b, now, now)
+	assertEqual(t, len(orders), 1, "")
+	assertEqual(t, len(orders[0].Users), n, "")
+
+	// a late archive of an older copy does not overwrite the newer one
+	var older, newer Order
+	UpdateOrder(b, func(order *Order) error {
+		order.ClearUser(User{"user0", "0"})
+		older = *order
+		return nil
+	})
+	UpdateOrder(b, func(order *Order) error {
+		order.ClearUser(User{"user1", "1"})
+		newer = *order
+		return nil
+	})
+	archiveOrder(b, &newer)
+	archiveOrder(b, &older)
+	orders = loadArchive(b, now, now)
+	assertEqual(t, len(orders[0].Users), n-2, "")
+}
+
+func TestStats(t *testing.T) {
+	var pasta, pollo, frutta UserChoice
+	pasta.Add(tuttobene.MenuRow{Content: "Pasta", Type: tuttobene.Primo})
+	pollo.Add(tuttobene.MenuRow{Content: "Pollo", Type: tuttobene.Secondo})
+	pollo.Add(tuttobene.MenuRow{Content: "Patate", Type: tuttobene.Contorno})
+	frutta.Add(tuttobene.MenuRow{Content: "Mela", Type: tuttobene.Frutta})
+
+	me := User{"me", "1"}
+	o1 := NewOrder()
+	o1.Timestamp = time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	o1.Set(me, []UserChoice{pasta, frutta})
+	o1.Set(User{"other", "2"}, []UserChoice{pollo})
+	o2 := NewOrder()
+	o2.Timestamp = time.Date(2026, 10, 6, 12, 0, 0, 0, time.UTC)
+	o2.Set(me, []UserChoice{pasta})
+	o2.Set(User{"guest_x", ""}, []UserChoice{pollo})
+
+	s := computeStats([]Order{*o1, *o2})
+	assertEqual(t, s.Lunches, 4, "")
+	assertEqual(t, s.Guests, 1, "")
+	assertEqual(t, strings.Join(ranking(s.Dishes, 2), ","), "Pasta,Patate", "")
+
+	out := s.format(nil)
+	assertEqual(t, strings.Contains(out, "Pranzi: 4 in 2 giorni (media 2.0 al giorno)"), true, out)
+	assertEqual(t, strings.Contains(out, "lunedì 05/10: 2"), true, out)
+	assertEqual(t, strings.Contains(out, "primi 40% (2), secondi 40% (2), frutta e dolci 20% (1)"), true, out)
+	assertEqual(t, strings.Contains(out, "2 Pollo con Patate"), true, out)
+	assertEqual(t, strings.Contains(out, "me: 2\nguest_x: 1\nother: 1"), true, out)
+
+	out = s.format(&me)
+	assertEqual(t, strings.Contains(out, "*I tuoi pranzi:* 2"), true, out)
+	assertEqual(t, strings.Contains(out, "other"), false, out)
+
+	now := time.Date(2026, 10, 8, 15, 0, 0, 0, time.UTC)
+	from, to, err := statsPeriod("settimana", now)
+	assertEqual(t, err, nil, "")
+	assertEqual(t, from.Format("02/01"), "05/10", "")
+	assertEqual(t, to.Format("02/01"), "08/10", "")
+	from, to, _ = statsPeriod("02/2026", now)
+	assertEqual(t, from.Format("02/01"), "01/02", "")
+	assertEqual(t, to.Format("02/01"), "28/02", "")
+	_, _, err = statsPeriod("ieri", now)
+	assertNotEqual(t, err, nil, "")
+}
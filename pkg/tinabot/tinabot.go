@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"os"
 	"strings"
 
 	"github.com/nlopes/slack"
@@ -36,13 +37,32 @@ func sanitize(s string) string {
 	return s
 }
 
+// isAdmin returns true if the user is a Slack workspace admin or is listed in the
+// TINABOT_ADMINS environment variable (comma separated user names or IDs)
+func isAdmin(user *slack.User) bool {
+	if user.IsAdmin || user.IsOwner {
+		return true
+	}
+
+	for _, a := range strings.Split(os.Getenv("TINABOT_ADMINS"), ",") {
+		a = strings.TrimSpace(a)
+		if a != "" && (a == user.ID || strings.EqualFold(a, user.Name)) {
+			return true
+		}
+	}
+	return false
+}
+
 type TinaBot struct {
-	bot   *slackbot.Bot
-	brain *brain.Brain
+	bot     *slackbot.Bot
+	brain   *brain.Brain
+	pricing *PricingRules
+	codes   *MarkCodes
 }
 
 func New(bot *slackbot.Bot, b *brain.Brain) *TinaBot {
-	return &TinaBot{bot, b}
+	bot.SetStore(b)
+	return &TinaBot{bot, b, LoadPricingRules(b), LoadMarkCodes(b)}
 }
 
 func (t *TinaBot) AddCommands() {
@@ -56,6 +76,12 @@ func (t *TinaBot) AddCommands() {
 	})
 
 	t.bot.RespondTo("^(?i)per (\\S+) (.*)$", t.For)
+	t.bot.RespondToConversation(dishConversation, t.answerDish)
+
+	t.bot.RespondTo("^(?i)ordina$", t.Wizard)
+	t.bot.RespondToConversation(wizardConversation, t.answerWizard)
+
+	t.bot.RespondTo("^(?i)annulla(.*)$", t.Undo)
 
 	t.bot.RespondTo("^(?i)ordine$", func(b *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
 		order := getOrder(t.brain)
@@ -64,12 +90,22 @@ func (t *TinaBot) AddCommands() {
 
 	t.bot.RespondTo("^(?i)conto$", func(b *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
 		order := getOrder(t.brain)
-		t.bot.Message(msg.Channel, "Ecco il conto:\n"+order.Bill())
+		t.bot.Message(msg.Channel, "Ecco il conto:\n"+order.BillWithBudget(loadBudget(t.brain)))
 	})
 
 	t.bot.RespondTo("^(?i)cancella ordine$", func(b *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
-		order := NewOrder()
-		order.Save(t.brain)
+		var old Order
+		err := UpdateOrder(t.brain, func(order *Order) error {
+			old = *order
+			*order = *NewOrder()
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			t.bot.Message(msg.Channel, "Non sono riuscito a cancellare l'ordine, riprova: "+err.Error())
+			return
+		}
+		pushUndoClear(t.brain, &old, User{user.Name, user.ID})
 		t.bot.Message(msg.Channel, "Ordine cancellato")
 	})
 
@@ -89,12 +125,18 @@ func (t *TinaBot) AddCommands() {
 	t.bot.RespondTo("^(?i)menu([\\s\\S]*)?", func(b *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
 
 		showPrices := false
-
-		if strings.TrimSpace(args[1]) == "price" {
-			showPrices = true
-		} else if args[1] != "" {
-			t.bot.Message(msg.Channel, "Se stai cercando di impostare il menù, usa il comando `setmenu`\nPer vedere il menù corrente, usa il comando `menu` senza argomenti.")
-			return
+		var mark func(tuttobene.MenuRow) string
+
+		for _, a := range strings.Fields(args[1]) {
+			switch strings.ToLower(a) {
+			case "price":
+				showPrices = true
+			case "dieta":
+				mark = dietMenuMarker(t.brain, user.ID)
+			default:
+				t.bot.Message(msg.Channel, "Se stai cercando di impostare il menù, usa il comando `setmenu`\nPer vedere il menù corrente, usa il comando `menu` senza argomenti.")
+				return
+			}
 		}
 
 		var m tuttobene.Menu
@@ -102,7 +144,7 @@ func (t *TinaBot) AddCommands() {
 		if err == redis.Nil {
 			t.bot.Message(msg.Channel, "Non c'è nessun menù impostato!")
 		} else {
-			t.bot.Message(msg.Channel, "Ecco il menù:\n"+m.Format(showPrices))
+			t.bot.Message(msg.Channel, "Ecco il menù:\n"+m.FormatMarked(showPrices, mark))
 		}
 	})
 
@@ -116,6 +158,7 @@ func (t *TinaBot) AddCommands() {
 			}
 			t.brain.Set("menu", *m)
 			t.bot.Message(msg.Channel, "Ok, menù impostato:\n"+m.String())
+			t.PlaceStandingOrders(*m)
 		} else {
 			t.bot.Message(msg.Channel, "Non hai indicato nessun nuovo menù!")
 		}
@@ -158,8 +201,39 @@ func (t *TinaBot) AddCommands() {
 	t.bot.RespondTo("^(?i)cron(.*)$", t.Cron)
 
 	t.bot.RespondTo("^(?i)remind(.*)$", t.Remind)
+	t.bot.RespondTo("^(?i)ricordami(.*)$", t.Snooze)
+
+	t.bot.RespondTo("^(?i)oggi (non )?ci sono$", t.Today)
+	t.bot.RespondTo("^(?i)assente(.*)$", t.Absent)
+
+	t.bot.RespondTo("^(?i)segna([\\s\\S]*)$", t.Mark)
 
-	t.bot.RespondTo("^(?i)segna(.*)$", t.Mark)
+	t.bot.RespondTo("^(?i)ogni(\\s.*)?$", t.Standing)
+
+	t.bot.RespondTo("^(?i)dieta(.*)$", t.Diet)
+
+	t.bot.RespondTo("^(?i)ospit[ei](.*)$", t.Guests)
+
+	t.bot.RespondTo("^(?i)statistiche(.*)$", t.Stats)
+
+	t.bot.RespondTo("^(?i)spese(.*)$", t.Expenses)
+
+	t.bot.RespondTo("^(?i)pagato(.*)$", t.Paid)
+	t.bot.RespondTo("^(?i)salda(.*)$", t.Settle)
+	t.bot.RespondTo("^(?i)saldo$", t.Balance)
+
+	t.bot.RespondTo("^(?i)budget(.*)$", t.BudgetCmd)
+
+	t.bot.RespondTo("^(?i)chiusur[ae](.*)$", t.Closures)
+
+	t.bot.RespondTo("^(?i)sondaggio(.*)$", t.Poll)
+	t.bot.RespondToAction(pollCallback, t.votePoll)
+
+	t.bot.RespondTo("^(?i)voti(.*)$", t.Ratings)
+	t.bot.RespondToConversation(ratingConversation, t.answerRating)
+
+	t.bot.RespondTo("^(?i)prezzi([\\s\\S]*)$", t.Pricing)
+	t.bot.RespondToAction(standingCallback, t.cancelStandingOrder)
 
 	t.bot.RespondTo("^(?i)rmorder (.*)$", func(b *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
 		u := args[1]
@@ -168,14 +242,24 @@ func (t *TinaBot) AddCommands() {
 		if finduser != nil {
 			name = User{finduser.Name, finduser.ID}
 		}
-		order := getOrder(t.brain)
-		old := order.ClearUser(name)
+		var old string
+		var entry undoEntry
+		err := UpdateOrder(t.brain, func(order *Order) error {
+			entry = newUndoEntry(order, "rmorder "+name.Name, name)
+			old = order.ClearUser(name)
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			t.bot.Message(msg.Channel, "Non sono riuscito a salvare l'ordine, riprova: "+err.Error())
+			return
+		}
+		pushUndo(t.brain, User{user.Name, user.ID}, entry)
+
 		if old != "" {
 			t.bot.Message(msg.Channel, fmt.Sprintf("Ok, cancello ordine di %s:\n%s", name.Name, old))
 		} else {
 			t.bot.Message(msg.Channel, fmt.Sprintf("%s non aveva ordinato nulla", name.Name))
 		}
-
-		order.Save(t.brain)
 	})
 }
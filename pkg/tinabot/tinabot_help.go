@@ -8,6 +8,7 @@ const HelpStr = `Elenco comandi supportati da tinabot9000:
 *<utente>* può essere ‘me‘ per ordinare per se stessi, oppure il nome di un altro utente slack (che verrà avvisato!). *E' possibile ordinare per ospiti esterni senza utente slack* chiamandoli ‘guest_<nome>‘.
 
 *<ordine>* può essere una serie di stringhe separate da spazi, tinabot9000 cercherà di fare un il meglio che può per capire il piatto tra le voci presenti nel menù.
+Se la stringa corrisponde a più piatti, tinabot9000 te li elencherà numerati: rispondi con il numero del piatto che intendevi (entro 5 minuti, non serve menzionare il bot).
 
 *E' possibile usare le seguenti funzionalità speciali per personalizzare l'ordine:*
 *&* - E commerciale
@@ -44,6 +45,28 @@ Aggiungo testualmente: 'pasta senza glutine al ragù'
 Ok, aggiunto 1 piatto per batt
 ‘‘‘
 
+*[ ]* - Parentesi quadre
+Una nota tra parentesi quadre dopo il nome di un piatto viene aggiunta al piatto ordinato, mantenendone prezzo e tipo. Usatela per richieste come "senza cipolla" o "ben cotto".
+‘‘‘
+@Tinabot 9000 per me lasagne [senza besciamella]
+
+Tinabot 9000:
+Trovato: Lasagne al ragù (primi piatti)
+Con la nota: senza besciamella
+Ok, aggiunto 1 piatto per batt
+‘‘‘
+
+*<n>x* - Quantità
+Anteponendo ‘<n>x‘ ad un piatto se ne ordinano *<n>* uguali, utile ad esempio per gli ospiti
+‘‘‘
+@Tinabot 9000 per guest_cliente 3x pasta al pomodoro
+
+Tinabot 9000:
+Trovato: Pasta al pomodoro (primi piatti)
+Quantità: 3
+Ok, aggiunti 3 piatti per guest_cliente
+‘‘‘
+
 *come* - Copia ordine
 Indicando "per me come <utente>", tinabot9000 copierà l'ordine dell'utente indicato
 ‘‘‘
@@ -57,40 +80,162 @@ Ok, aggiunto 1 piatto per batt
 
 Le funzionalità speciali possono anche essere combinate tra loro
 
+*PER ORDINARE PASSO PASSO:*
+‘@Tinabot 9000 ordina‘
+tinabot9000 ti scriverà in privato proponendoti, una sezione alla volta, i piatti del menù di oggi (primi, secondi, contorni, frutta e dolci, panini): rispondi con il numero del piatto o ‘0‘ per saltare la sezione. Alla fine vedrai il riepilogo con il prezzo e potrai confermare l'ordine.
+
+*PER REGISTRARE UN OSPITE:*
+Quando ordini per un ospite (‘guest_<nome>‘) il suo pranzo viene addebitato a te. Per indicare un altro padrone di casa o un progetto/azienda a cui addebitarlo:
+‘@Tinabot 9000 ospite guest_<nome> [di <utente>] [progetto <progetto>]‘
+‘‘‘
+@Tinabot 9000 ospite guest_cliente di djeasy progetto acme
+Tinabot 9000:
+Ok, guest_cliente è registrato come ospite di djeasy, progetto acme
+‘‘‘
+Nell'ordine l'ospite comparirà come "(ospite di <utente>)" e il padrone di casa riceverà il riepilogo del suo pranzo. Nel ‘conto‘ il pranzo degli ospiti è sommato a quello del padrone di casa o del progetto, anche per il calcolo del budget.
+Per vedere gli ospiti registrati: ‘@Tinabot 9000 ospiti‘, per rimuoverne uno: ‘@Tinabot 9000 ospite guest_<nome> off‘
+Per vedere il riepilogo degli ospiti del mese: ‘@Tinabot 9000 ospiti mese [mm/aaaa]‘
+
+*PER MODIFICARE UN ORDINE:*
+‘@Tinabot 9000 per <utente> aggiungi <ordine>‘ aggiunge uno o più piatti all'ordine esistente
+‘@Tinabot 9000 per <utente> togli <piatto>‘ toglie un piatto (anche un contorno di un piatto personalizzato)
+‘@Tinabot 9000 per <utente> sostituisci <piatto> con <ordine>‘ sostituisce un piatto
+‘‘‘
+@Tinabot 9000 per me aggiungi macedonia
+Tinabot 9000:
+Trovato: Macedonia (frutta)
+Ok, ordine di batt modificato:
+Fusilli con salsiccia pomodoro e olive
+Macedonia
+‘‘‘
+
 *PER CANCELLARE UN ORDINE:*
 ‘@Tinabot 9000 per <utente> niente‘
-*<utente>* può essere ‘me‘ o il nome di un altro utente slack (che verrà avvisato). 
+*<utente>* può essere ‘me‘ o il nome di un altro utente slack (che verrà avvisato).
+
+*PER ANNULLARE L'ULTIMA MODIFICA:*
+‘@Tinabot 9000 annulla‘
+Annulla l'ultima modifica che hai fatto all'ordine di oggi (un ordine, una modifica o una cancellazione, anche per altri utenti, che verranno avvisati). Ripetendo il comando si annullano le modifiche precedenti, fino alle ultime 10.
+‘‘‘
+@Tinabot 9000 annulla
+Tinabot 9000:
+Ok, ho annullato 'per me niente', ecco l'ordine:
+...
+‘‘‘
+Un amministratore può ripristinare l'ordine cancellato con ‘@Tinabot 9000 cancella ordine‘ usando ‘@Tinabot 9000 annulla cancella ordine‘.
+
+*PER IMPOSTARE UN ORDINE FISSO:*
+‘@Tinabot 9000 ogni <giorni>: <ordine>‘
+Quando viene impostato il menù di uno dei *<giorni>* indicati, tinabot9000 proverà ad ordinare per te *<ordine>* (con la stessa sintassi di ‘per me‘), a meno che tu non abbia già ordinato. Riceverai un messaggio privato di conferma con un bottone per annullare l'ordine.
+‘‘‘
+@Tinabot 9000 ogni ven: pasta al pomodoro
+Tinabot 9000:
+Ok, venerdì ordinerò per te: pasta al pomodoro
+‘‘‘
+Per vedere gli ordini fissi impostati: ‘@Tinabot 9000 ogni‘
+Per rimuoverli: ‘@Tinabot 9000 ogni <giorni>: niente‘ oppure ‘@Tinabot 9000 ogni off‘
 
 *PER VEDERE I PIATTI ORDINATI:*
 ‘@Tinabot 9000 ordine‘
 
+*PER VEDERE LE STATISTICHE:*
+‘@Tinabot 9000 statistiche [periodo]‘
+*[periodo]* può essere ‘settimana‘, ‘mese‘ (il default), ‘anno‘ oppure un mese come ‘mm/aaaa‘.
+Mostra il numero di pranzi per giorno o per settimana, i piatti più ordinati, la percentuale di primi e secondi, i piatti composti più ordinati, il numero di ospiti e i pranzi di ogni utente. Se lo chiedi in privato vedrai solo i tuoi pranzi.
+
+*PER VEDERE I VOTI DEI PIATTI:*
+Dopo pranzo Tinabot 9000 chiede a chi ha ordinato un voto da 1 a 5 per ogni piatto, con un commento facoltativo.
+‘@Tinabot 9000 voti <piatto>‘ mostra la media dei voti del piatto e gli ultimi commenti, ‘@Tinabot 9000 voti‘ i piatti più votati.
+
+*PER VEDERE LE TUE SPESE:*
+‘@Tinabot 9000 spese [mm/aaaa]‘
+tinabot9000 ti scriverà in privato l'elenco dei pranzi del mese (quello corrente se non indicato), con i piatti, il prezzo e il codice segnato sul foglio dei pranzi, oltre al totale speso e al numero di pranzi da confrontare con i buoni pasto. Sono compresi i pranzi dei tuoi ospiti, a meno che non siano addebitati ad un progetto.
+Un amministratore può ottenere il foglio excel con le spese di tutti con ‘@Tinabot 9000 spese tutti [mm/aaaa]‘.
+
+*PER REGISTRARE CHI HA PAGATO:*
+‘@Tinabot 9000 pagato <importo>‘
+Registra che hai pagato il conto di oggi: ad ogni partecipante viene addebitata la sua parte secondo il conto (i pranzi degli ospiti sono addebitati al padrone di casa).
+‘@Tinabot 9000 salda <utente> <importo>‘ registra che hai restituito *<importo>* a *<utente>*, che verrà avvisato.
+‘@Tinabot 9000 saldo‘ mostra chi deve dare e chi deve ricevere.
+‘‘‘
+@Tinabot 9000 pagato 42,50
+Tinabot 9000:
+Ok, batt ha pagato €42.50 per l'ordine di oggi:
+djeasy deve €7.50
+...
+‘‘‘
+
+*PER VEDERE IL BUDGET DEL PRANZO:*
+‘@Tinabot 9000 budget‘
+Mostra quanto del pranzo è coperto dall'azienda; il resto è a carico tuo. Quando ordini più del tuo budget tinabot9000 ti avviserà, e con ‘@Tinabot 9000 conto‘ vedrai per ogni utente la parte coperta e quella a suo carico.
+Un amministratore può impostare il budget predefinito con ‘@Tinabot 9000 budget <importo>‘, quello di un ruolo con ‘@Tinabot 9000 budget ruolo <ruolo> <importo>‘, assegnare un ruolo con ‘@Tinabot 9000 budget <utente> ruolo <ruolo>‘ o impostare il budget di un singolo utente con ‘@Tinabot 9000 budget <utente> <importo>‘ (‘off‘ al posto del valore lo rimuove).
+
+*PER VEDERE LE REGOLE DEI PREZZI:*
+‘@Tinabot 9000 prezzi‘
+Di base il prezzo di un piatto composto è quello più alto tra le sue portate. Un amministratore può impostare le regole in formato JSON con ‘@Tinabot 9000 prezzi <regole>‘ (‘default‘ le ripristina), ad esempio:
+‘‘‘
+{"Combos": [{"Name": "Proposta con frutta", "Types": ["secondo", "frutta"], "DailyProposal": true, "Price": "8"}],
+ "IncludedContorni": 1, "ExtraContorno": "1.5"}
+‘‘‘
+I tipi di piatto validi sono: ‘primo‘, ‘secondo‘, ‘contorno‘, ‘vegetariano‘, ‘frutta‘, ‘dolce‘, ‘panino‘, ‘testuale‘.
+Il prezzo di un piatto è fissato quando viene ordinato: le nuove regole non cambiano gli ordini già fatti né le spese passate.
+
+*PER VEDERE LE CHIUSURE DELL'UFFICIO:*
+‘@Tinabot 9000 chiusure‘
+Mostra le chiusure dell'ufficio in programma e i prossimi giorni festivi (compresi Pasquetta e San Giovanni). Nei giorni di chiusura e nei festivi Tinabot 9000 non invia reminder, mail e messaggi automatici e non segna il pranzo.
+Un amministratore può aggiungere una chiusura con ‘@Tinabot 9000 chiusura <gg/mm>-<gg/mm> [nota]‘ (o un solo giorno con ‘chiusura <gg/mm>‘) e rimuoverla con ‘@Tinabot 9000 chiusura rm <n>‘.
+
+*PER ANDARE A PRANZO FUORI:*
+‘@Tinabot 9000 sondaggio pranzo [alle <orario>] [<opzioni>]‘
+Apre un sondaggio nel canale con un pulsante per ogni *<opzione>* (al massimo 5, separate da virgola), per scegliere dove andare a pranzo invece di ordinare. Il sondaggio chiude alle 11:45 se non indicato e Tinabot 9000 annuncia il risultato: a chi ha votato l'opzione vincente e non ha ordinato viene segnato il pranzo fuori sul foglio dei pranzi.
+‘@Tinabot 9000 sondaggio opzioni <opzioni>‘ imposta le opzioni usate se non indicate, ‘@Tinabot 9000 sondaggio‘ mostra i voti e ‘@Tinabot 9000 sondaggio chiudi‘ chiude il sondaggio in anticipo.
+
 *PER INVIARE LA MAIL AL TUTTOBENE:*
 ‘@Tinabot 9000 email‘
 Verrà fornito un link che autocompone una mail nel client di posta locale. Chiunque può inviare la mail al tuttobene.
 
 *PER VEDERE IL MENÙ DEI PIATTI:*
 ‘@Tinabot 9000 menu‘
+Con ‘@Tinabot 9000 menu dieta‘ i piatti compatibili con il tuo profilo alimentare saranno evidenziati con :white_check_mark:, quelli che potrebbero non esserlo con :x:.
+
+*PER IMPOSTARE IL PROFILO ALIMENTARE:*
+‘@Tinabot 9000 dieta <restrizioni>‘
+*<restrizioni>* è un elenco separato da virgole tra: ‘vegetariano‘, ‘vegano‘, ‘celiaco‘, ‘no lattosio‘, ‘frutta secca‘, ‘no pesce‘, ‘no carne‘, ‘no uova‘.
+Quando ordini un piatto che potrebbe non essere compatibile con il tuo profilo, tinabot9000 ti avviserà (senza bloccare l'ordine). Il controllo si basa sul nome dei piatti, quindi è solo indicativo!
+‘‘‘
+@Tinabot 9000 dieta vegetariano, no lattosio
+Tinabot 9000:
+Ok, profilo alimentare impostato: vegetariano, no lattosio
+‘‘‘
+Per vedere il profilo: ‘@Tinabot 9000 dieta‘, per rimuoverlo: ‘@Tinabot 9000 dieta off‘
 
 *PER IMPOSTARE IL MENÙ DEI PIATTI:*
 ‘@Tinabot 9000 setmenu <stringa menu>‘
 *<stringa menu>* può essere multilinea. E' sufficiente copiare le celle dal file excel inviato per mail dal tuttobene. Chiunque può impostare il menù.
 
 *PER IMPOSTARE IL REMINDER:*
-Nel caso tu abbia attivato la funzionalità reminder, se è impostato un menù valido per il giorno e non hai ancora ordinato, alle 11:50 ti verrà inviato un messaggio privato contenente il menù del giorno.
+Nel caso tu abbia attivato la funzionalità reminder, se è impostato un menù valido per il giorno e non hai ancora ordinato, all'orario scelto (le 11:50 se non indicato) ti verrà inviato un messaggio privato contenente il menù del giorno.
 Ecco come fare:
-‘@Tinabot 9000 remind <giorni>‘
+‘@Tinabot 9000 remind <giorni> [<orario>]‘
 *<giorni>* può essere ‘on‘ per indicare tutti i giorni:
 ‘‘‘
 @Tinabot 9000 remind on
 Tinabot 9000:
-Reminder attivo tutti i giorni
+Reminder attivo tutti i giorni alle 11:50
 ‘‘‘
 
 E' anche possibile specificare i singoli giorni separati da virgola:
 ‘‘‘
 @Tinabot 9000 remind lun, mar
 Tinabot 9000:
-Reminder attivo lunedì, martedì
+Reminder attivo lunedì, martedì alle 11:50
+‘‘‘
+
+Per ricevere il reminder ad un orario diverso indicalo dopo i giorni (oppure da solo per cambiare solo l'orario):
+‘‘‘
+@Tinabot 9000 remind lun, mer 11:30
+Tinabot 9000:
+Reminder attivo lunedì, mercoledì alle 11:30
 ‘‘‘
 
 Per disattivare il reminder usare ‘off‘:
@@ -103,13 +248,29 @@ Reminder disattivato
 *PER VEDERE LO STATO DEL REMINDER:*
 ‘@Tinabot 9000 remind‘
 
+*PER POSTICIPARE IL REMINDER:*
+Se ricevi il reminder ma non sei ancora pronto per ordinare, rispondi nel messaggio privato con:
+‘ricordami tra <n> minuti‘ oppure ‘ricordami tra <n> ore‘
+e Tinabot 9000 ti invierà di nuovo il menù più tardi, se nel frattempo non avrai ordinato.
+
+*PER SEGNALARE UN'ASSENZA:*
+Se non sei in ufficio Tinabot 9000 non ti manda il reminder e non ti conta tra chi non ha ancora ordinato:
+‘@Tinabot 9000 oggi non ci sono‘ oppure ‘@Tinabot 9000 oggi ci sono‘ per annullare
+‘@Tinabot 9000 assente dal <giorno> al <giorno>‘, dove *<giorno>* è il giorno del mese (‘3‘) oppure giorno e mese (‘3/11‘)
+‘@Tinabot 9000 assente remoto <giorni>‘ per i giorni fissi di lavoro da remoto, con gli stessi *<giorni>* del reminder
+‘@Tinabot 9000 assente niente on‘ per segnare in automatico ‘Niente‘ sul foglio dei pranzi quando sei assente (‘off‘ per disattivare)
+‘@Tinabot 9000 assente off‘ cancella le assenze, ‘@Tinabot 9000 assente‘ le mostra.
+
 *PER SEGNARE IL PRANZO:*
 Tinabot 9000 è in grado di segnare *in automatico* il pranzo sul foglio google di riepilogo, usato dall'amministrazione per tenere traccia dei pasti e dei buoni.
 Se hai ordinato il pranzo con Tinabot, *verrà registrato in automatico alle 14:00*.
 E' comunque possibile modificare quanto segnato sul foglio manualmente:
 ‘@Tinabot 9000 segna <cibo>‘
-*<cibo>* può essere:
+*<cibo>* è uno dei codici del foglio dei pranzi, normalmente:
 ‘P‘, ‘PS‘, ‘PD‘, ‘S‘, ‘SD‘, ‘D‘, ‘PSD‘ oppure ‘Niente‘
+Per vedere i codici validi e come vengono calcolati dall'ordine:
+‘@Tinabot 9000 segna codici‘
+Un amministratore può cambiarli con ‘@Tinabot 9000 segna codici <regole in JSON>‘ (o ripristinare quelli predefiniti con ‘segna codici default‘), es. ‘{"Rules": [{"Types": ["panino"], "Code": "Panino"}, {"Types": ["primo"], "Code": "P"}], "Default": "S", "Nothing": "Niente", "Valid": ["P", "S", "Panino", "Niente"]}‘
 
 es. 
 ‘‘‘
@@ -118,5 +279,9 @@ Tinabot 9000:
 Ok, segnato 'P' per batt sul foglio dei pranzi
 ‘‘‘
 
+Per sapere se il tuo pranzo di oggi è stato segnato correttamente:
+‘@Tinabot 9000 segna stato‘
+Se qualcosa va storto, Tinabot 9000 riproverà più tardi in automatico. Gli amministratori vedono lo stato di tutti.
+
 
 `
@@ -2,6 +2,8 @@ package tinabot
 
 import (
 	"testing"
+
+	"github.com/develersrl/lunches/pkg/tuttobene"
 )
 
 func TestSplitSep(t *testing.T) {
@@ -30,3 +32,82 @@ func TestSplitSep(t *testing.T) {
 
 	}
 }
+
+func TestSplitNote(t *testing.T) {
+	tests := map[string][2]string{
+		"lasagne":                               {"lasagne", ""},
+		" lasagne [senza besciamella] ":         {"lasagne", "senza besciamella"},
+		"lasagne[senza besciamella]":            {"lasagne", "senza besciamella"},
+		"bistecca [ben cotta] [senza sale]":     {"bistecca", "ben cotta, senza sale"},
+		"\"pasta senza glutine\" [al pomodoro]": {"\"pasta senza glutine\"", "al pomodoro"},
+		"pasta [] ":                             {"pasta", ""},
+	}
+
+	for i, want := range tests {
+		dish, note := splitNote(i)
+		if dish != want[0] || note != want[1] {
+			t.Fatalf("Error, wanted %v, got %v, %v", want, dish, note)
+		}
+	}
+}
+
+func TestSplitQuantity(t *testing.T) {
+	n, req, err := splitQuantity("3x pasta al pomodoro")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, n, 3, "")
+	assertEqual(t, req, "pasta al pomodoro", "")
+
+	n, req, err = splitQuantity(" 2 X lasagne")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, n, 2, "")
+	assertEqual(t, req, "lasagne", "")
+
+	n, req, err = splitQuantity("xavier")
+	assertEqual(t, err, nil, "")
+	assertEqual(t, n, 1, "")
+	assertEqual(t, req, "xavier", "")
+
+	_, _, err = splitQuantity("0x lasagne")
+	assertNotEqual(t, err, nil, "")
+	_, _, err = splitQuantity("100x lasagne")
+	assertNotEqual(t, err, nil, "")
+}
+
+func TestReplaceDish(t *testing.T) {
+	tests := []struct {
+		request, dish, content, want string
+		after                        int
+	}{
+		{"pasta", "pasta", "Pasta al pomodoro", "Pasta al pomodoro", 0},
+		{"2x Pasta [al dente] + pollo", "pasta", "Pasta al pesto", "2x Pasta al pesto [al dente] + pollo", 0},
+		{"pollo &amp; patate", "patate", "Patate + salsa", "pollo &amp; Patate \\+ salsa", 0},
+		{"pollo", "manzo", "Manzo", "pollo", 0},
+		{"pasta al pesto + pasta", "pasta", "Pasta al pomodoro", "pasta al pesto + Pasta al pomodoro", 0},
+		{"pasta + pasta al pesto", "pasta", "Pasta al pomodoro", "Pasta al pomodoro + pasta al pesto", 1},
+		{"sostituisci pasta con pasta", "pasta", "Pasta al pomodoro", "sostituisci pasta con Pasta al pomodoro", 0},
+	}
+
+	for _, tt := range tests {
+		out := replaceDish(tt.request, tt.dish, tt.content, tt.after)
+		assertEqual(t, out, tt.want, "")
+	}
+}
+
+func TestAmbiguousDishAfter(t *testing.T) {
+	menu := tuttobene.Menu{
+		Rows: []tuttobene.MenuRow{
+			{Content: "Pasta al pesto", Type: tuttobene.Primo},
+			{Content: "Pasta al pomodoro", Type: tuttobene.Primo},
+			{Content: "Pollo arrosto", Type: tuttobene.Secondo},
+		},
+	}
+
+	for _, req := range []string{"pasta al pesto + pasta", "pasta + pollo [niente pasta]", "pasta + pasta al pesto"} {
+		_, _, err := parseOrder(menu, req)
+		amb, ok := err.(*ambiguousDishError)
+		assertEqual(t, ok, true, "")
+		out := replaceDish(req, amb.Dish, "Pasta al pomodoro", amb.After)
+		_, _, err = parseOrder(menu, out)
+		assertEqual(t, err, nil, out)
+	}
+}
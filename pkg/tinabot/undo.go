@@ -0,0 +1,184 @@
+package tinabot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nlopes/slack"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+)
+
+// maxUndo is the number of actions that each user can undo
+const maxUndo = 10
+
+// undoEntry holds the choices of the users before an action changed them
+type undoEntry struct {
+	Action string
+	Date   string
+	Before map[User]UserChoiceArray // an empty array means that the user had not ordered
+	Guests map[string]Guest         `json:",omitempty"` // hosts of the guests in Before
+}
+
+func orderDate(order *Order) string {
+	return order.Timestamp.Format("2006-01-02")
+}
+
+func newUndoEntry(order *Order, action string, users ...User) undoEntry {
+	entry := undoEntry{
+		Action: action,
+		Date:   orderDate(order),
+		Before: make(map[User]UserChoiceArray),
+	}
+	for _, u := range users {
+		entry.Before[u] = append(UserChoiceArray{}, order.Users[u]...)
+		if g, ok := order.guest(u); ok {
+			if entry.Guests == nil {
+				entry.Guests = make(map[string]Guest)
+			}
+			entry.Guests[u.Name] = g
+		}
+	}
+	return entry
+}
+
+// pushUndo records the entry, created with newUndoEntry before the requester changed the order
+func pushUndo(brain DataStore, requester User, entry undoEntry) {
+	var stacks map[string][]undoEntry
+	err := brain.Update("undo", &stacks, func() error {
+		if stacks == nil {
+			stacks = make(map[string][]undoEntry)
+		}
+		stack := append(stacks[requester.ID], entry)
+		if len(stack) > maxUndo {
+			stack = stack[len(stack)-maxUndo:]
+		}
+		stacks[requester.ID] = stack
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// pushUndoClear records the whole order before it is cancelled with "cancella ordine"
+func pushUndoClear(brain DataStore, order *Order, requester User) {
+	var users []User
+	for u := range order.Users {
+		users = append(users, u)
+	}
+	pushUndo(brain, requester, newUndoEntry(order, "cancella ordine", users...))
+	brain.Set("undo_clear", newUndoEntry(order, fmt.Sprintf("cancella ordine di %s", requester.Name), users...))
+}
+
+// popUndo removes and returns the last undoable action of the requester for the current order
+func popUndo(brain DataStore, order *Order, requester User) (undoEntry, bool) {
+	var stacks map[string][]undoEntry
+	var entry undoEntry
+	var found bool
+	err := brain.Update("undo", &stacks, func() error {
+		entry, found = undoEntry{}, false
+		stack := stacks[requester.ID]
+		// drop the actions done on older orders
+		for len(stack) > 0 && stack[len(stack)-1].Date != orderDate(order) {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			delete(stacks, requester.ID)
+			return nil
+		}
+
+		entry, found = stack[len(stack)-1], true
+		stacks[requester.ID] = stack[:len(stack)-1]
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		return undoEntry{}, false
+	}
+	return entry, found
+}
+
+// restore puts back in the order the choices saved in the entry
+func (entry undoEntry) restore(order *Order) {
+	for u, choices := range entry.Before {
+		if len(choices) == 0 {
+			order.ClearUser(u)
+		} else {
+			order.Set(u, choices)
+		}
+		if g, ok := entry.Guests[u.Name]; ok {
+			order.SetGuest(u.Name, g)
+		}
+	}
+}
+
+// Undo reverts the last change to the order done by the user
+func (t *TinaBot) Undo(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	me := User{user.Name, user.ID}
+	order := getOrder(t.brain)
+
+	var entry undoEntry
+	clear := false
+	if strings.TrimSpace(strings.ToLower(args[1])) == "cancella ordine" {
+		if !isAdmin(user) {
+			bot.Message(msg.Channel, "Mi spiace, solo un amministratore può annullare la cancellazione dell'ordine di un altro utente")
+			return
+		}
+		err := t.brain.Get("undo_clear", &entry)
+		if err != nil || entry.Date != orderDate(order) {
+			bot.Message(msg.Channel, "Oggi l'ordine non è stato cancellato, non c'è nulla da annullare")
+			return
+		}
+		clear = true
+	} else if strings.TrimSpace(args[1]) != "" {
+		bot.Message(msg.Channel, "Usa `annulla` per annullare la tua ultima modifica all'ordine")
+		return
+	} else {
+		var ok bool
+		entry, ok = popUndo(t.brain, order, me)
+		if !ok {
+			bot.Message(msg.Channel, "Non hai fatto modifiche all'ordine di oggi, non c'è nulla da annullare")
+			return
+		}
+	}
+
+	err := UpdateOrder(t.brain, func(o *Order) error {
+		entry.restore(o)
+		order = o
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		if !clear {
+			// the order has not changed, the action can still be undone
+			pushUndo(t.brain, me, entry)
+		}
+		bot.Message(msg.Channel, "Non sono riuscito a salvare l'ordine, riprova: "+err.Error())
+		return
+	}
+	if clear {
+		t.brain.Set("undo_clear", nil)
+	}
+	log.Printf("%s undid '%s'\n", user.Name, entry.Action)
+
+	bot.Message(msg.Channel, fmt.Sprintf("Ok, ho annullato '%s', ecco l'ordine:\n%s", entry.Action, order.String()))
+
+	for u := range entry.Before {
+		if u.ID == "" || u.ID == user.ID {
+			continue
+		}
+		_, _, ch, err := bot.Client.OpenIMChannel(u.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		current := order.Users[u].String()
+		if current == "" {
+			current = "nessun piatto"
+		}
+		bot.Message(ch, fmt.Sprintf("Ti volevo informare che <@%s> ha annullato '%s', ecco i piatti ordinati per conto tuo:\n%s", user.ID, entry.Action, current))
+	}
+}
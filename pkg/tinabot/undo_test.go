@@ -0,0 +1,68 @@
+package tinabot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/develersrl/lunches/pkg/brain"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestUndo(t *testing.T) {
+	b := brain.NewBrainMock()
+	order := NewOrder()
+	me := User{"me", "1"}
+	other := User{"other", "2"}
+
+	var pasta, pesce UserChoice
+	pasta.Add(tuttobene.MenuRow{Content: "Pasta al pomodoro", Type: tuttobene.Primo})
+	pesce.Add(tuttobene.MenuRow{Content: "Pesce spada", Type: tuttobene.Secondo})
+
+	pushUndo(b, me, newUndoEntry(order, "per me pasta", me))
+	order.Set(me, []UserChoice{pasta})
+	pushUndo(b, me, newUndoEntry(order, "per other pesce", other))
+	order.Set(other, []UserChoice{pesce})
+	pushUndo(b, me, newUndoEntry(order, "per me pesce", me))
+	order.Set(me, []UserChoice{pesce})
+
+	_, ok := popUndo(b, order, other)
+	assertEqual(t, ok, false, "")
+
+	entry, ok := popUndo(b, order, me)
+	assertEqual(t, ok, true, "")
+	assertEqual(t, entry.Action, "per me pesce", "")
+	entry.restore(order)
+	assertEqual(t, order.Users[me].String(), "Pasta al pomodoro", "")
+	assertEqual(t, len(order.Dishes["Pesce spada"]), 1, "")
+
+	entry, _ = popUndo(b, order, me)
+	entry.restore(order)
+	_, ok = order.Users[other]
+	assertEqual(t, ok, false, "")
+	assertEqual(t, len(order.Dishes["Pesce spada"]), 0, "")
+
+	entry, _ = popUndo(b, order, me)
+	entry.restore(order)
+	assertEqual(t, len(order.Users), 0, "")
+
+	_, ok = popUndo(b, order, me)
+	assertEqual(t, ok, false, "")
+}
+
+func TestUndoOldOrder(t *testing.T) {
+	b := brain.NewBrainMock()
+	order := NewOrder()
+	me := User{"me", "1"}
+
+	pushUndo(b, me, newUndoEntry(order, "per me pasta", me))
+	for i := 0; i < maxUndo+5; i++ {
+		pushUndo(b, me, newUndoEntry(order, "per me niente", me))
+	}
+	stacks := make(map[string][]undoEntry)
+	b.Get("undo", &stacks)
+	assertEqual(t, len(stacks[me.ID]), maxUndo, "")
+
+	order.Timestamp = order.Timestamp.Add(24 * time.Hour)
+	_, ok := popUndo(b, order, me)
+	assertEqual(t, ok, false, "")
+}
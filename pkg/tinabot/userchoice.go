@@ -15,12 +15,47 @@ import (
 type UserChoice struct {
 	DishMask uint
 	Dishes   []tuttobene.MenuRow
+	Notes    map[string]string `json:",omitempty"` // user notes (e.g. "senza cipolla") keyed by dish name
+	Quantity int               `json:",omitempty"` // number of identical dishes, 0 means 1
+	// UnitPrice is the price of a single dish, set by the pricing rules when the choice is ordered
+	UnitPrice decimal.Decimal
+}
+
+// Count returns the number of identical dishes ordered with this choice
+func (u *UserChoice) Count() int {
+	if u.Quantity < 1 {
+		return 1
+	}
+	return u.Quantity
+}
+
+// Label returns the choice description, prefixed by the quantity if more than one dish was ordered
+func (u *UserChoice) Label() string {
+	if u.Count() > 1 {
+		return fmt.Sprintf("%dx %s", u.Count(), u.String())
+	}
+	return u.String()
 }
 
 // Clear clears the current user choice
 func (u *UserChoice) Clear() {
 	u.DishMask = 0
 	u.Dishes = nil
+	u.Notes = nil
+	u.Quantity = 0
+	u.UnitPrice = decimal.Zero
+}
+
+// SetNote attaches a note to the dish of the choice, an empty note removes it
+func (u *UserChoice) SetNote(dish, note string) {
+	if note == "" {
+		delete(u.Notes, dish)
+		return
+	}
+	if u.Notes == nil {
+		u.Notes = make(map[string]string)
+	}
+	u.Notes[dish] = note
 }
 
 // Customized returns true if the user choosed to customize her dish adding one or more side dishes
@@ -57,6 +92,7 @@ const (
 	dessertDish = "3"
 )
 
+// mark returns the category of the choice, used by the statistics
 func (u *UserChoice) mark() string {
 	if u.DishMask&(1<<uint(tuttobene.Primo)|1<<uint(tuttobene.Panino)) != 0 {
 		return firstDish
@@ -75,21 +111,29 @@ func (u *UserChoice) mark() string {
 
 func (u *UserChoice) sort() {
 	sort.Slice(u.Dishes, func(i, j int) bool {
-		si := fmt.Sprintf("%d%s", u.Dishes[i].Type, u.Dishes[i].Content)
-		sj := fmt.Sprintf("%d%s", u.Dishes[j].Type, u.Dishes[j].Content)
+		si := fmt.Sprintf("%d%s", u.Dishes[i].Type, u.dishString(u.Dishes[i]))
+		sj := fmt.Sprintf("%d%s", u.Dishes[j].Type, u.dishString(u.Dishes[j]))
 		return strings.Compare(si, sj) < 0
 	})
 }
 
+// dishString returns the dish name followed by the user note, if any
+func (u *UserChoice) dishString(d tuttobene.MenuRow) string {
+	if note, ok := u.Notes[d.Content]; ok {
+		return d.Content + " [" + note + "]"
+	}
+	return d.Content
+}
+
 func (u *UserChoice) String() string {
 	u.sort()
 	var main []string
 	var side []string
 	for _, d := range u.Dishes {
 		if d.Type == tuttobene.Secondo {
-			main = append(main, d.Content)
+			main = append(main, u.dishString(d))
 		} else {
-			side = append(side, d.Content)
+			side = append(side, u.dishString(d))
 		}
 	}
 	out := strings.Join(main, ", ")
@@ -107,39 +151,30 @@ func (u *UserChoice) OrdString() string {
 	return fmt.Sprintf("%04d-%s", u.DishMask, u.String())
 }
 
+// Price returns the price of a single dish of the choice, the one set when it was ordered.
+// The choices without a price, e.g. the ones ordered before the prices were saved, get the base price.
 func (u *UserChoice) Price() decimal.Decimal {
-	p := decimal.Zero
-
-	for _, d := range u.Dishes {
-		p = decimal.Max(p, d.Price)
+	if !u.UnitPrice.IsZero() {
+		return u.UnitPrice
 	}
-
-	return p
+	return (&PricingRules{}).Price(u)
 }
 
 type UserChoiceArray []UserChoice
 
-func (u UserChoiceArray) Mark() string {
-	var marks []string
+// Price returns the total price of the choices, quantities included
+func (u UserChoiceArray) Price() decimal.Decimal {
+	p := decimal.Zero
 	for _, c := range u {
-		marks = append(marks, c.mark())
+		p = p.Add(c.Price().Mul(decimal.New(int64(c.Count()), 0)))
 	}
-	sort.Strings(marks)
-	out := strings.Join(marks, "")
-	out = strings.Replace(out, noDish, "", -1)
-	out = strings.Replace(out, firstDish, "P", -1)
-	out = strings.Replace(out, secondDish, "S", -1)
-	out = strings.Replace(out, dessertDish, "D", -1)
-	if out != "" {
-		return out
-	}
-	return "Niente"
+	return p
 }
 
 func (u UserChoiceArray) String() string {
 	var choices []string
 	for _, c := range u {
-		choices = append(choices, c.String())
+		choices = append(choices, c.Label())
 	}
 	return strings.Join(choices, "\n")
 }
@@ -0,0 +1,257 @@
+package tinabot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/shopspring/decimal"
+
+	"github.com/develersrl/lunches/pkg/slackbot"
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+const wizardConversation = "wizard"
+
+// wizardStep is a step of the guided ordering, asking for a dish among the given menu sections
+type wizardStep struct {
+	Question string
+	Types    []tuttobene.MenuRowType
+	Multi    bool
+}
+
+var wizardSteps = []wizardStep{
+	{"Vuoi un primo?", []tuttobene.MenuRowType{tuttobene.Primo}, false},
+	{"Vuoi un secondo?", []tuttobene.MenuRowType{tuttobene.Secondo, tuttobene.Vegetariano}, false},
+	{"Vuoi dei contorni? Puoi sceglierne più di uno separando i numeri con la virgola.", []tuttobene.MenuRowType{tuttobene.Contorno}, true},
+	{"Vuoi frutta o dolce?", []tuttobene.MenuRowType{tuttobene.Frutta, tuttobene.Dolce}, false},
+	{"Vuoi un panino?", []tuttobene.MenuRowType{tuttobene.Panino}, false},
+}
+
+func (s wizardStep) options(menu tuttobene.Menu) []tuttobene.MenuRow {
+	var rows []tuttobene.MenuRow
+	for _, r := range menu.Rows {
+		for _, t := range s.Types {
+			if r.Type == t {
+				rows = append(rows, r)
+				break
+			}
+		}
+	}
+	return rows
+}
+
+// parseNumbers parses a comma separated list of 1-based option numbers, 0 means none
+func parseNumbers(text string, max int) ([]int, bool) {
+	var out []int
+	for _, s := range strings.Split(text, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 0 || n > max {
+			return nil, false
+		}
+		if n > 0 {
+			out = append(out, n-1)
+		}
+	}
+	return out, true
+}
+
+// addWizardDishes adds the chosen dishes to the choices: contorni are added to the last
+// choice if it is a secondo, every other dish is a new choice.
+func addWizardDishes(choices []UserChoice, dishes []tuttobene.MenuRow) ([]UserChoice, error) {
+	for _, d := range dishes {
+		if d.Type == tuttobene.Contorno && len(choices) > 0 {
+			last := &choices[len(choices)-1]
+			if last.DishMask&(1<<uint(tuttobene.Secondo)|1<<uint(tuttobene.Vegetariano)|1<<uint(tuttobene.Contorno)) != 0 &&
+				last.DishMask&^(1<<uint(tuttobene.Secondo)|1<<uint(tuttobene.Vegetariano)|1<<uint(tuttobene.Contorno)) == 0 {
+				if err := last.Add(d); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		var c UserChoice
+		if err := c.Add(d); err != nil {
+			return nil, err
+		}
+		choices = append(choices, c)
+	}
+	return choices, nil
+}
+
+func formatWizardSummary(pricing *PricingRules, choices []UserChoice) string {
+	total := decimal.Zero
+	out := ""
+	for _, c := range choices {
+		price := pricing.Price(&c).Mul(decimal.New(int64(c.Count()), 0))
+		total = total.Add(price)
+		if price.IsZero() {
+			out += c.Label() + " -> *prezzo non disponibile*\n"
+		} else {
+			out += fmt.Sprintf("%s -> €%s\n", c.Label(), price.String())
+		}
+	}
+	return out + fmt.Sprintf("*Totale: €%s*", total.String())
+}
+
+func loadTodayMenu(brain DataStore) (tuttobene.Menu, error) {
+	var menu tuttobene.Menu
+	err := brain.Get("menu", &menu)
+	if err != nil {
+		return menu, fmt.Errorf("Nessun menù impostato!")
+	}
+
+	if !menu.IsUpdated() {
+		return menu, fmt.Errorf("Non puoi ordinare, il menù non è quello di oggi, riporta la data del %s", menu.Date.Format("02/01/2006"))
+	}
+	return menu, nil
+}
+
+// askWizardStep asks the first step starting from step with some dishes in the menu, or the
+// confirmation if all the steps have been done
+func (t *TinaBot) askWizardStep(channel, userID string, menu tuttobene.Menu, step int, choices []UserChoice, intro string) {
+	encoded, err := json.Marshal(choices)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for ; step < len(wizardSteps); step++ {
+		rows := wizardSteps[step].options(menu)
+		if len(rows) == 0 {
+			continue
+		}
+
+		var options []string
+		for _, r := range rows {
+			options = append(options, r.Content)
+		}
+
+		t.bot.Ask(channel, userID, intro+wizardSteps[step].Question+" Rispondi con il numero, `0` per saltare oppure `stop` per uscire:",
+			slackbot.Conversation{
+				Action:  wizardConversation,
+				Options: options,
+				Data: map[string]string{
+					"step":   strconv.Itoa(step),
+					"choice": string(encoded),
+				},
+			})
+		return
+	}
+
+	if len(choices) == 0 {
+		t.bot.Message(channel, intro+"Non hai scelto nessun piatto, ordine non aggiunto")
+		return
+	}
+
+	t.bot.Ask(channel, userID, intro+"Ecco il riepilogo del tuo ordine:\n"+formatWizardSummary(t.pricing, choices)+"\nRispondi `1` per confermare oppure `0` per annullare:",
+		slackbot.Conversation{
+			Action: wizardConversation,
+			Data: map[string]string{
+				"step":   strconv.Itoa(step),
+				"choice": string(encoded),
+			},
+		})
+}
+
+// Wizard starts the guided ordering in a direct message
+func (t *TinaBot) Wizard(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, args ...string) {
+	menu, err := loadTodayMenu(t.brain)
+	if err != nil {
+		bot.Message(msg.Channel, err.Error())
+		return
+	}
+
+	ch := msg.Channel
+	if !strings.HasPrefix(ch, "D") {
+		_, _, ch, err = bot.Client.OpenIMChannel(user.ID)
+		if err != nil {
+			log.Println(err)
+			bot.Message(msg.Channel, "Non riesco a scriverti in privato, riprova più tardi")
+			return
+		}
+		bot.Message(msg.Channel, "Ok, ti scrivo in privato per guidarti nell'ordine")
+	}
+
+	intro := "Ciao " + user.Name + ", ti guido nell'ordine di oggi.\n"
+	order := getOrder(t.brain)
+	if old, ok := order.Users[User{user.Name, user.ID}]; ok {
+		intro += "Attenzione, hai già ordinato:\n" + old.String() + "\nconfermando il nuovo ordine verrà sostituito.\n"
+	}
+	t.askWizardStep(ch, user.ID, menu, 0, nil, intro)
+}
+
+// answerWizard handles the answers to the guided ordering steps
+func (t *TinaBot) answerWizard(bot *slackbot.Bot, msg *slackbot.BotMsg, user *slack.User, conv *slackbot.Conversation, text string) bool {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "stop" || text == "annulla" {
+		bot.Message(msg.Channel, "Ok, ordine non aggiunto")
+		return true
+	}
+
+	menu, err := loadTodayMenu(t.brain)
+	if err != nil {
+		bot.Message(msg.Channel, err.Error())
+		return true
+	}
+
+	step, _ := strconv.Atoi(conv.Data["step"])
+	var choices []UserChoice
+	json.Unmarshal([]byte(conv.Data["choice"]), &choices)
+
+	if step >= len(wizardSteps) {
+		switch text {
+		case "1":
+			me := User{user.Name, user.ID}
+			var list []string
+			var entry undoEntry
+			err := UpdateOrder(t.brain, func(order *Order) error {
+				entry = newUndoEntry(order, "ordina", me)
+				list = setChoices(t.brain, t.pricing, order, me, me, choices)
+				return nil
+			})
+			if err != nil {
+				log.Println(err)
+				bot.Message(msg.Channel, "Non sono riuscito a salvare l'ordine, riprova: "+err.Error())
+				return true
+			}
+			pushUndo(t.brain, me, entry)
+			bot.Message(msg.Channel, dietWarnings(t.brain, me, choices)+budgetWarning(t.brain, me, choices)+"Ok, ordine aggiunto:\n"+strings.Join(list, "\n"))
+		case "0":
+			bot.Message(msg.Channel, "Ok, ordine non aggiunto")
+		default:
+			t.askWizardStep(msg.Channel, user.ID, menu, step, choices, "Non ho capito. ")
+		}
+		return true
+	}
+
+	multi := wizardSteps[step].Multi
+	numbers, ok := parseNumbers(text, len(conv.Options))
+	if !ok || (!multi && len(numbers) > 1) {
+		t.askWizardStep(msg.Channel, user.ID, menu, step, choices, "Non ho capito. ")
+		return true
+	}
+
+	var dishes []tuttobene.MenuRow
+	for _, n := range numbers {
+		found := findDishes(menu, conv.Options[n])
+		if len(found) != 1 {
+			bot.Message(msg.Channel, fmt.Sprintf("Il piatto '%s' non è più nel menù, ricomincia con `ordina`", conv.Options[n]))
+			return true
+		}
+		dishes = append(dishes, found[0])
+	}
+
+	newChoices, err := addWizardDishes(choices, dishes)
+	if err != nil {
+		t.askWizardStep(msg.Channel, user.ID, menu, step, choices, "Errore nella personalizzazione: "+err.Error()+"\n")
+		return true
+	}
+
+	t.askWizardStep(msg.Channel, user.ID, menu, step+1, newChoices, "")
+	return true
+}
@@ -0,0 +1,50 @@
+package tinabot
+
+import (
+	"testing"
+
+	"github.com/develersrl/lunches/pkg/tuttobene"
+)
+
+func TestParseNumbers(t *testing.T) {
+	n, ok := parseNumbers("2", 3)
+	assertEqual(t, ok, true, "")
+	assertEqual(t, len(n), 1, "")
+	assertEqual(t, n[0], 1, "")
+
+	n, ok = parseNumbers("1, 3", 3)
+	assertEqual(t, ok, true, "")
+	assertEqual(t, len(n), 2, "")
+
+	n, ok = parseNumbers("0", 3)
+	assertEqual(t, ok, true, "")
+	assertEqual(t, len(n), 0, "")
+
+	_, ok = parseNumbers("4", 3)
+	assertEqual(t, ok, false, "")
+	_, ok = parseNumbers("uno", 3)
+	assertEqual(t, ok, false, "")
+}
+
+func TestAddWizardDishes(t *testing.T) {
+	p := tuttobene.MenuRow{Content: "primo", Type: tuttobene.Primo}
+	s := tuttobene.MenuRow{Content: "secondo", Type: tuttobene.Secondo}
+	c1 := tuttobene.MenuRow{Content: "contorno1", Type: tuttobene.Contorno}
+	c2 := tuttobene.MenuRow{Content: "contorno2", Type: tuttobene.Contorno}
+	f := tuttobene.MenuRow{Content: "frutta", Type: tuttobene.Frutta}
+
+	choices, err := addWizardDishes(nil, []tuttobene.MenuRow{p})
+	assertEqual(t, err, nil, "")
+	choices, err = addWizardDishes(choices, []tuttobene.MenuRow{s})
+	assertEqual(t, err, nil, "")
+	choices, err = addWizardDishes(choices, []tuttobene.MenuRow{c1, c2})
+	assertEqual(t, err, nil, "")
+	choices, err = addWizardDishes(choices, []tuttobene.MenuRow{f})
+	assertEqual(t, err, nil, "")
+	assertEqual(t, UserChoiceArray(choices).String(), "primo\nsecondo con contorno1, contorno2\nfrutta", "")
+
+	// without a secondo the contorni are a plate on their own
+	choices, err = addWizardDishes(nil, []tuttobene.MenuRow{p, c1, c2})
+	assertEqual(t, err, nil, "")
+	assertEqual(t, UserChoiceArray(choices).String(), "primo\ncontorno1, contorno2", "")
+}
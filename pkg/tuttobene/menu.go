@@ -51,6 +51,11 @@ func (m *Menu) String() string {
 }
 
 func (m *Menu) Format(withPrices bool) string {
+	return m.FormatMarked(withPrices, nil)
+}
+
+// FormatMarked formats the menu like Format, prefixing each row with the string returned by mark
+func (m *Menu) FormatMarked(withPrices bool, mark func(MenuRow) string) string {
 	menutype := Unknonwn
 
 	out := "Data: *" + m.Date.Format("02/01/2006") + "*\n"
@@ -59,6 +64,9 @@ func (m *Menu) Format(withPrices bool) string {
 			out = out + "\n*" + strings.ToUpper(Titles[r.Type]) + "*\n"
 			menutype = r.Type
 		}
+		if mark != nil {
+			out += mark(r)
+		}
 		if r.IsDailyProposal {
 			out += "Proposta del giorno: "
 		}